@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ydssx/api-gen/gen"
+)
+
+// runAdopt derives annotated types from the routes and handlers of an
+// existing gin service and appends them to the type file.
+func runAdopt(args []string) error {
+	fs := flag.NewFlagSet("adopt", flag.ExitOnError)
+	configFile := fs.String("c", "config.yaml", "path to config file")
+	helpers := fs.String("resp", "OKWithData", "comma-separated response helpers taking the response as last argument")
+	dryRun := fs.Bool("dry-run", false, "print the annotated types instead of writing the type file")
+	asJSON := fs.Bool("json", false, "print the adopted apis as JSON")
+	fs.Parse(args)
+
+	cfg, err := gen.LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	apis, err := gen.Adopt(cfg, gen.AdoptOptions{RespHelpers: strings.Split(*helpers, ","), DryRun: *dryRun})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(apis)
+	}
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH\tGROUP\tREQUEST\tRESPONSE\tSKIPPED")
+	for _, api := range apis {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", api.Method, api.Path, api.Group, api.Request, api.Response, api.Skipped)
+	}
+	return w.Flush()
+}
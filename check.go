@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ydssx/api-gen/gen"
+)
+
+// runCheck reports every mismatch between the types, handlers, logic and
+// routes, and exits non-zero when there is any.
+func runCheck(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	configFile := fs.String("c", "config.yaml", "path to config file")
+	asJSON := fs.Bool("json", false, "print the issues as JSON")
+	fs.Parse(args)
+
+	cfg, err := gen.LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	issues, err := gen.Check(cfg)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(issues); err != nil {
+			return err
+		}
+	} else {
+		for _, issue := range issues {
+			fmt.Println(issue)
+		}
+	}
+
+	if len(issues) > 0 {
+		fmt.Fprintf(os.Stderr, "%d issue(s) found\n", len(issues))
+		os.Exit(1)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ydssx/api-gen/gen"
+)
+
+// runDiff reports the changes of the annotated apis since a base version,
+// and exits non-zero when there is any unapproved breaking change.
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	configFile := fs.String("c", "config.yaml", "path to config file")
+	base := fs.String("base", "", "base version of the type file, a file or a git revision")
+	approve := fs.String("approve", "", "file listing the keys of the approved breaking changes")
+	asJSON := fs.Bool("json", false, "print the changes as JSON")
+	fs.Parse(args)
+
+	if *base == "" {
+		return errors.New("-base is required")
+	}
+	cfg, err := gen.LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	changes, err := gen.Diff(cfg, *base)
+	if err != nil {
+		return err
+	}
+	if *approve != "" {
+		if err := gen.ApproveChanges(changes, *approve); err != nil {
+			return err
+		}
+	}
+
+	breaking := 0
+	for _, c := range changes {
+		if c.Breaking && !c.Approved {
+			breaking++
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(changes); err != nil {
+			return err
+		}
+	} else {
+		for _, c := range changes {
+			fmt.Println(c)
+			if c.Breaking && !c.Approved {
+				fmt.Printf("\tapprove with: %s\n", c.Key())
+			}
+		}
+	}
+
+	if breaking > 0 {
+		fmt.Fprintf(os.Stderr, "%d unapproved breaking change(s) found\n", breaking)
+		os.Exit(1)
+	}
+	return nil
+}
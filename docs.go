@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/ydssx/api-gen/gen"
+)
+
+// runDocs renders the annotated apis as a Markdown API reference.
+func runDocs(args []string) error {
+	fs := flag.NewFlagSet("docs-md", flag.ExitOnError)
+	configFile := fs.String("c", "config.yaml", "path to config file")
+	output := fs.String("o", "", "output file, overrides docs.file of the config")
+	templates := fs.String("templates", "", "directory of templates, overrides docs.templates of the config")
+	seed := fs.Int64("seed", 1, "seed of the faked example values")
+	fs.Parse(args)
+
+	cfg, err := gen.LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		cfg.Docs.File = *output
+	}
+	if *templates != "" {
+		cfg.Docs.Templates = *templates
+	}
+	_, err = gen.GenDocs(cfg, *seed)
+	return err
+}
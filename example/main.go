@@ -7,7 +7,6 @@ import (
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	_ "github.com/ydssx/api-gen/example/docs"
-	"github.com/ydssx/api-gen/example/middleware"
 	"github.com/ydssx/api-gen/example/router"
 )
 
@@ -29,7 +28,6 @@ func main() {
 
 	{
 		user := v1.Group("/user")
-		user.Use(middleware.JwtMiddleware())
 		router.UserRouter(user)
 	}
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
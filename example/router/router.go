@@ -3,6 +3,7 @@ package router
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/ydssx/api-gen/example/handler"
+	"github.com/ydssx/api-gen/example/middleware"
 )
 
 type routerGroup func(*gin.RouterGroup)
@@ -10,7 +11,7 @@ type routerGroup func(*gin.RouterGroup)
 // this is a comments
 func UserRouter(rg *gin.RouterGroup) {
 	// asadsafdf
-	rg.POST("/login", handler.LoginHandler)
+	rg.POST("/login", middleware.JwtMiddleware(), handler.LoginHandler)
 	user := rg.Group("user")
 	{
 		// this is dfdf
@@ -24,7 +25,7 @@ func UserRouter(rg *gin.RouterGroup) {
 			// comment apiv2
 			v2.GET("api2222")
 		}
-		api.POST("/login", handler.LoginHandler)
+		api.POST("/login", middleware.JwtMiddleware(), handler.LoginHandler)
 	}
 	// comentde dsaas
 	rg.DELETE("delete")
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ydssx/api-gen/gen"
+)
+
+// runExport exports the annotated apis as a Postman collection or a .http
+// file.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configFile := fs.String("c", "config.yaml", "path to config file")
+	format := fs.String("format", "postman", "export format, postman or http")
+	output := fs.String("o", "", "output file, stdout by default")
+	baseURL := fs.String("base-url", "http://localhost:8080", "value of the baseUrl variable")
+	seed := fs.Int64("seed", 1, "seed of the faked example values")
+	fs.Parse(args)
+
+	cfg, err := gen.LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	data, err := gen.Export(cfg, gen.ExportOptions{Format: *format, BaseURL: *baseURL, Seed: *seed})
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		return err
+	}
+	fmt.Println("File", *output, "written.")
+	return nil
+}
@@ -0,0 +1,555 @@
+package gen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// AdoptOptions configures Adopt.
+type AdoptOptions struct {
+	// RespHelpers are the names of the functions writing a response, which
+	// take the response as their last argument, like OKWithData.
+	RespHelpers []string
+	// DryRun prints the annotated types instead of writing the type file.
+	DryRun bool
+}
+
+// AdoptedAPI is an api derived from a route of the router function.
+type AdoptedAPI struct {
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Group    string `json:"group,omitempty"`
+	Handler  string `json:"handler"`
+	Request  string `json:"request,omitempty"`  // Go type bound by ShouldBind, empty if none
+	Response string `json:"response,omitempty"` // Go type passed to the response helper, empty if none
+	Skipped  string `json:"skipped,omitempty"`  // reason the route was not adopted
+}
+
+// Adopt derives annotated types from the routes of the router function of
+// the config and their handlers, and appends them to the type file, so that
+// hand-written gin services come under api-gen management. Routes already
+// annotated in the type file are skipped.
+func Adopt(cfg Config, opts AdoptOptions) ([]AdoptedAPI, error) {
+	if len(opts.RespHelpers) == 0 {
+		opts.RespHelpers = []string{"OKWithData"}
+	}
+	tree, err := BuildRouteTree(cfg.Router.File, cfg.Router.GroupFunc)
+	if err != nil {
+		return nil, err
+	}
+	a, err := newAdopter(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var walk func(node *RouteNode, prefix []string)
+	walk = func(node *RouteNode, prefix []string) {
+		if node.Path != "" {
+			prefix = append(prefix, node.Path)
+		}
+		group := strings.Trim(joinPath(prefix...), "/")
+		for _, route := range node.Routes {
+			a.adopt(group, route)
+		}
+		for _, child := range node.Children {
+			walk(child, prefix)
+		}
+	}
+	walk(tree, nil)
+
+	if len(a.decls) == 0 {
+		return a.apis, nil
+	}
+	if opts.DryRun {
+		for _, path := range a.importList() {
+			fmt.Printf("// import %q\n", path)
+		}
+		fmt.Print(strings.Join(a.decls, "\n"))
+		return a.apis, nil
+	}
+	return a.apis, a.write()
+}
+
+// adopter resolves the handlers of the routes with the type-checked packages
+// of the module.
+type adopter struct {
+	cfg         Config
+	opts        AdoptOptions
+	typesPkg    string // import path of the package of the type file
+	handlerFile string // absolute path of handler.file
+	scope       *types.Scope
+	handlers    map[string]*types.Func // handler expression of the router function -> function
+	funcs       map[*types.Func]adoptFunc
+	comments    map[token.Pos]string       // position of a type or field name -> comment
+	existing    map[string]bool            // METHOD path and handler functions of the annotated apis
+	names       map[string]bool            // types declared or copied into the type file
+	copied      map[*types.TypeName]string // structs copied into the type file -> their name there
+	imports     map[string]bool
+	decls       []string
+	apis        []AdoptedAPI
+}
+
+type adoptFunc struct {
+	decl *ast.FuncDecl
+	info *types.Info
+	file string // absolute path of the file declaring the function
+}
+
+func newAdopter(cfg Config, opts AdoptOptions) (*adopter, error) {
+	typesPkg, err := pkgPath(cfg.TypeFile)
+	if err != nil {
+		return nil, err
+	}
+	root, err := moduleRoot(filepath.Dir(cfg.Router.File))
+	if err != nil {
+		return nil, err
+	}
+	routerFile, err := filepath.Abs(cfg.Router.File)
+	if err != nil {
+		return nil, err
+	}
+	handlerFile, err := filepath.Abs(cfg.Handler.File)
+	if err != nil {
+		return nil, err
+	}
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
+		Dir:  root,
+	}, "./...")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load packages")
+	}
+
+	a := &adopter{
+		cfg:         cfg,
+		opts:        opts,
+		typesPkg:    typesPkg,
+		handlerFile: handlerFile,
+		handlers:    map[string]*types.Func{},
+		funcs:       map[*types.Func]adoptFunc{},
+		comments:    map[token.Pos]string{},
+		existing:    map[string]bool{},
+		names:       map[string]bool{},
+		copied:      map[*types.TypeName]string{},
+		imports:     map[string]bool{},
+	}
+	for _, pkg := range pkgs {
+		if pkg.PkgPath == typesPkg && pkg.Types != nil {
+			a.scope = pkg.Types.Scope()
+		}
+		for _, file := range pkg.Syntax {
+			filename := pkg.Fset.Position(file.Pos()).Filename
+			isRouter := filename == routerFile
+			ast.Inspect(file, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.FuncDecl:
+					if fn, ok := pkg.TypesInfo.Defs[n.Name].(*types.Func); ok {
+						a.funcs[fn] = adoptFunc{decl: n, info: pkg.TypesInfo, file: filename}
+					}
+					if isRouter && n.Recv == nil && n.Name.Name == cfg.Router.GroupFunc {
+						a.resolveHandlers(n, pkg.TypesInfo)
+					}
+				case *ast.GenDecl:
+					specDoc(n)
+				case *ast.TypeSpec:
+					if c := commentText(n.Doc, n.Comment); c != "" {
+						a.comments[n.Name.Pos()] = c
+					}
+				case *ast.Field:
+					if c := commentText(n.Doc, n.Comment); c != "" {
+						for _, name := range n.Names {
+							a.comments[name.Pos()] = c
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+	if a.scope != nil {
+		for _, name := range a.scope.Names() {
+			a.names[name] = true
+		}
+	}
+
+	if _, err := os.Stat(cfg.TypeFile); err == nil {
+		m, err := ParseModel(cfg.TypeFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, api := range m.APIs {
+			a.existing[api.Method+" "+joinPath(api.Group, api.Path)] = true
+			a.existing[api.Handler+"Handler"] = true
+		}
+	}
+	return a, nil
+}
+
+// resolveHandlers maps the expressions of the router function to the
+// functions they refer to, like handler.LoginHandler.
+func (a *adopter) resolveHandlers(fn *ast.FuncDecl, info *types.Info) {
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		var ident *ast.Ident
+		switch e := n.(type) {
+		case *ast.SelectorExpr:
+			ident = e.Sel
+		case *ast.Ident:
+			ident = e
+		default:
+			return true
+		}
+		if f, ok := info.Uses[ident].(*types.Func); ok {
+			a.handlers[types.ExprString(n.(ast.Expr))] = f
+		}
+		return true
+	})
+}
+
+// adopt derives the annotated types of a route.
+func (a *adopter) adopt(group string, route *Route) {
+	api := AdoptedAPI{Method: route.Method, Path: route.Path, Group: group, Handler: route.Handler}
+	defer func() { a.apis = append(a.apis, api) }()
+
+	fn, ok := a.handlers[route.Handler]
+	switch {
+	case route.Method == "ANY":
+		api.Skipped = "Any routes have no method to annotate"
+		return
+	case a.existing[route.Method+" "+joinPath(group, route.Path)] || ok && a.existing[fn.Name()]:
+		api.Skipped = "already annotated"
+		return
+	case !ok || a.funcs[fn].decl == nil:
+		api.Skipped = "handler is not a function of the module"
+		return
+	case a.funcs[fn].file != a.handlerFile:
+		// 生成器只在 handler.file 中查找 handler，其他文件的会被重复生成
+		api.Skipped = "handler is not declared in " + a.cfg.Handler.File
+		return
+	}
+	name := strings.TrimSuffix(fn.Name(), "Handler")
+	if name == "" {
+		name = fn.Name()
+	}
+	api.Handler = string(unicode.ToLower(rune(name[0]))) + name[1:]
+	title := string(unicode.ToUpper(rune(name[0]))) + name[1:]
+
+	req, resp := a.bodies(a.funcs[fn])
+	var doc []string
+	if group != "" {
+		doc = append(doc, "// @group "+group)
+	}
+	doc = append(doc, "// @handler "+api.Handler)
+
+	var specs []string
+	for _, body := range []struct {
+		typ        types.Type
+		annotation string
+		name       string
+		result     *string
+	}{
+		{req, "@request", title + "Req", &api.Request},
+		{resp, "@response", title + "Resp", &api.Response},
+	} {
+		if body.typ == nil {
+			doc = append(doc, "// "+body.annotation+" "+NoBody)
+			continue
+		}
+		*body.result = types.TypeString(body.typ, nil)
+		switch t := types.Unalias(deref(body.typ)).(type) {
+		case *types.Named:
+			// 已复制的结构体沿用复制后的名字
+			if _, ok := a.copied[t.Obj()]; ok || !isStructType(t) || t.Obj().Pkg() == nil || t.Obj().Pkg().Path() == a.typesPkg {
+				break
+			}
+			if a.names[body.name] {
+				api.Skipped = fmt.Sprintf("type %s already exists", body.name)
+				return
+			}
+			a.names[body.name] = true
+			a.copied[t.Obj()] = body.name
+			spec := a.copyStruct(body.name, t.Underlying().(*types.Struct), t.Obj().Pkg(), t.Obj().Pos(), &specs)
+			specs = append(specs, spec)
+			continue
+		case *types.Struct:
+			// 匿名结构体以 Req/Resp 命名后复制
+			if a.names[body.name] {
+				api.Skipped = fmt.Sprintf("type %s already exists", body.name)
+				return
+			}
+			a.names[body.name] = true
+			spec := a.copyStruct(body.name, t, fn.Pkg(), token.NoPos, &specs)
+			specs = append(specs, spec)
+			continue
+		}
+		// 其他类型直接引用，其中 handler 所在包的结构体复制到类型文件
+		doc = append(doc, "// "+body.annotation+" "+a.typeString(body.typ, fn.Pkg(), &specs))
+	}
+	doc = append(doc, fmt.Sprintf("// @router %s [%s]", route.Path, strings.ToLower(route.Method)))
+
+	decl := strings.Join(doc, "\n") + "\ntype (\n" + strings.Join(specs, "\n\n") + "\n)\n"
+	a.decls = append(a.decls, decl)
+}
+
+// bodies returns the type bound by ShouldBind or Bind and the type passed to
+// a response helper in the handler. Responses of error branches and non-2xx
+// statuses are left out, and the last typed response wins.
+func (a *adopter) bodies(fn adoptFunc) (req, resp types.Type) {
+	var inspect func(n ast.Node) bool
+	inspect = func(n ast.Node) bool {
+		if stmt, ok := n.(*ast.IfStmt); ok {
+			// 只看 if err != nil 的 else 分支和 if err == nil 的主体
+			switch errCheck(fn.info, stmt.Cond) {
+			case token.NEQ:
+				for _, n := range []ast.Node{stmt.Init, stmt.Else} {
+					if n != nil {
+						ast.Inspect(n, inspect)
+					}
+				}
+				return false
+			case token.EQL:
+				for _, n := range []ast.Node{stmt.Init, stmt.Body} {
+					if n != nil {
+						ast.Inspect(n, inspect)
+					}
+				}
+				return false
+			}
+		}
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		if sel, ok := call.Fun.(*ast.SelectorExpr); ok && isGinContext(fn.info.TypeOf(sel.X)) {
+			name := sel.Sel.Name
+			switch {
+			case req == nil && (strings.HasPrefix(name, "ShouldBind") || strings.HasPrefix(name, "Bind")):
+				req = deref(fn.info.TypeOf(call.Args[0]))
+			case strings.HasSuffix(name, "JSON") && len(call.Args) == 2 && successStatus(fn.info, call.Args[0]):
+				if t := responseType(fn.info, call.Args[1]); t != nil {
+					resp = t
+				}
+			}
+			return true
+		}
+		if callee := typeutil.StaticCallee(fn.info, call); callee != nil {
+			for _, helper := range a.opts.RespHelpers {
+				if callee.Name() != helper {
+					continue
+				}
+				if t := responseType(fn.info, call.Args[len(call.Args)-1]); t != nil {
+					resp = t
+				}
+			}
+		}
+		return true
+	}
+	ast.Inspect(fn.decl.Body, inspect)
+	return req, resp
+}
+
+// errCheck returns the operator of a condition comparing an error with nil,
+// like err != nil, or token.ILLEGAL for other conditions.
+func errCheck(info *types.Info, cond ast.Expr) token.Token {
+	bin, ok := ast.Unparen(cond).(*ast.BinaryExpr)
+	if !ok || bin.Op != token.NEQ && bin.Op != token.EQL {
+		return token.ILLEGAL
+	}
+	errType := types.Universe.Lookup("error").Type()
+	for _, pair := range [][2]ast.Expr{{bin.X, bin.Y}, {bin.Y, bin.X}} {
+		if info.Types[pair[1]].IsNil() {
+			if t := info.TypeOf(pair[0]); t != nil && types.Identical(t, errType) {
+				return bin.Op
+			}
+		}
+	}
+	return token.ILLEGAL
+}
+
+// successStatus reports whether the status argument is a 2xx constant, or
+// not a constant at all.
+func successStatus(info *types.Info, arg ast.Expr) bool {
+	v := info.Types[arg].Value
+	if v == nil || v.Kind() != constant.Int {
+		return true
+	}
+	status, _ := constant.Int64Val(v)
+	return status >= 200 && status < 300
+}
+
+// responseType returns the type of a response argument, looking into the
+// Data field of an envelope literal like util.Response{Data: resp}. Untyped
+// responses like gin.H, and envelopes without Data, are ignored.
+func responseType(info *types.Info, arg ast.Expr) types.Type {
+	if lit, ok := ast.Unparen(arg).(*ast.CompositeLit); ok {
+		for _, elt := range lit.Elts {
+			if kv, ok := elt.(*ast.KeyValueExpr); ok {
+				if key, ok := kv.Key.(*ast.Ident); ok && key.Name == "Data" {
+					return responseType(info, kv.Value)
+				}
+			}
+		}
+		if t := info.TypeOf(lit); t != nil {
+			if st, ok := t.Underlying().(*types.Struct); ok {
+				for i := 0; i < st.NumFields(); i++ {
+					if st.Field(i).Name() == "Data" {
+						return nil
+					}
+				}
+			}
+		}
+	}
+	t := info.TypeOf(arg)
+	if t == nil {
+		return nil
+	}
+	if named, ok := types.Unalias(t).(*types.Named); ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == ginPkg {
+		return nil
+	}
+	switch types.Unalias(t).Underlying().(type) {
+	case *types.Interface:
+		return nil
+	case *types.Map:
+		if _, ok := types.Unalias(t).(*types.Named); !ok {
+			return nil
+		}
+	}
+	return t
+}
+
+// copyStruct returns the type spec copying the struct into the type file
+// under the given name. Structs of the same package it refers to are copied
+// too, under their own names, so that the type file does not import the
+// package of the handlers.
+func (a *adopter) copyStruct(name string, st *types.Struct, pkg *types.Package, pos token.Pos, specs *[]string) string {
+	var sb strings.Builder
+	if c := a.comments[pos]; c != "" {
+		sb.WriteString(protoComment(c, "\t"))
+	}
+	sb.WriteString("\t" + name + " struct {\n")
+	for i := 0; i < st.NumFields(); i++ {
+		v := st.Field(i)
+		if c := a.comments[v.Pos()]; c != "" {
+			sb.WriteString(protoComment(c, "\t\t"))
+		}
+		sb.WriteString("\t\t")
+		if !v.Embedded() {
+			sb.WriteString(v.Name() + " ")
+		}
+		sb.WriteString(a.typeString(v.Type(), pkg, specs))
+		if tag := st.Tag(i); tag != "" {
+			sb.WriteString(" `" + tag + "`")
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\t}")
+	return sb.String()
+}
+
+// typeString returns the type as written in the type file. Structs of the
+// package src are copied into specs.
+func (a *adopter) typeString(t types.Type, src *types.Package, specs *[]string) string {
+	switch t := types.Unalias(t).(type) {
+	case *types.Named:
+		obj := t.Obj()
+		if name, ok := a.copied[obj]; ok {
+			return name
+		}
+		if src != nil && obj.Pkg() == src && src.Path() != a.typesPkg && isStructType(t) && t.TypeArgs().Len() == 0 {
+			if !a.names[obj.Name()] {
+				a.names[obj.Name()] = true
+				a.copied[obj] = obj.Name()
+				spec := a.copyStruct(obj.Name(), t.Underlying().(*types.Struct), src, obj.Pos(), specs)
+				*specs = append(*specs, spec)
+			}
+			return obj.Name()
+		}
+	case *types.Pointer:
+		return "*" + a.typeString(t.Elem(), src, specs)
+	case *types.Slice:
+		return "[]" + a.typeString(t.Elem(), src, specs)
+	case *types.Array:
+		return fmt.Sprintf("[%d]%s", t.Len(), a.typeString(t.Elem(), src, specs))
+	case *types.Map:
+		return "map[" + a.typeString(t.Key(), src, specs) + "]" + a.typeString(t.Elem(), src, specs)
+	}
+	return types.TypeString(t, func(pkg *types.Package) string {
+		if pkg.Path() == a.typesPkg {
+			return ""
+		}
+		a.imports[pkg.Path()] = true
+		return pkg.Name()
+	})
+}
+
+func (a *adopter) importList() []string {
+	paths := make([]string, 0, len(a.imports))
+	for path := range a.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// write appends the annotated types to the type file, which is created when
+// it does not exist.
+func (a *adopter) write() error {
+	tx := NewTx()
+	pkg, err := dirPkgName(a.cfg.TypeFile)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ReadFile(a.cfg.TypeFile); os.IsNotExist(err) {
+		tx.WriteFile(a.cfg.TypeFile, []byte("package "+pkg+"\n"))
+	}
+	file, err := tx.ParseFile(a.cfg.TypeFile)
+	if err != nil {
+		return err
+	}
+	src, err := decorator.ParseFile(token.NewFileSet(), "", "package "+pkg+"\n\n"+strings.Join(a.decls, "\n"), 0)
+	if err != nil {
+		return errors.Wrap(err, "invalid annotated types")
+	}
+	for _, decl := range src.Decls {
+		decl.Decorations().Before = dst.EmptyLine
+		file.Decls = append(file.Decls, decl)
+	}
+	for _, path := range a.importList() {
+		addImport(file, path)
+	}
+	if err := tx.Write(a.cfg.TypeFile, file); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isGinContext reports whether t is gin.Context or a pointer to it.
+func isGinContext(t types.Type) bool {
+	named, ok := types.Unalias(deref(t)).(*types.Named)
+	return ok && named.Obj().Name() == "Context" && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == ginPkg
+}
+
+func isStructType(named *types.Named) bool {
+	_, ok := named.Underlying().(*types.Struct)
+	return ok
+}
+
+func deref(t types.Type) types.Type {
+	if ptr, ok := types.Unalias(t).(*types.Pointer); ok {
+		return ptr.Elem()
+	}
+	return t
+}
@@ -3,27 +3,94 @@ package gen
 import (
 	"log"
 	"os"
+	"path/filepath"
 
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	ApiPath  []string `yaml:"apiPath"`
-	TypeFile string   `yaml:"typeFile"`
+	ApiPath  []string `yaml:"apiPath" json:"apiPath"`
+	TypeFile string   `yaml:"typeFile" json:"typeFile"`
 
 	Logic struct {
-		File     string `yaml:"file"`
-		Receiver string `yaml:"receiver"`
-	} `yaml:"logic"`
+		File     string `yaml:"file" json:"file"`
+		Receiver string `yaml:"receiver" json:"receiver"`
+		Context  bool   `yaml:"context" json:"context"`
+	} `yaml:"logic" json:"logic"`
 
 	Handler struct {
-		File string `yaml:"file"`
-	} `yaml:"handler"`
+		File     string `yaml:"file" json:"file"`
+		Receiver string `yaml:"receiver" json:"receiver"`
+	} `yaml:"handler" json:"handler"`
 
 	Router struct {
-		File      string `yaml:"file"`
-		GroupFunc string `yaml:"groupFunc"`
-	} `yaml:"router"`
+		File      string `yaml:"file" json:"file"`
+		GroupFunc string `yaml:"groupFunc" json:"groupFunc"`
+		BasePath  string `yaml:"basePath" json:"basePath"`
+	} `yaml:"router" json:"router"`
+
+	// Verify formats the generated files with goimports and type-checks
+	// their packages after each api. Type errors the api introduced are
+	// reported, and its changes are reverted when Revert is set.
+	Verify struct {
+		Enabled bool `yaml:"enabled" json:"enabled"`
+		Revert  bool `yaml:"revert" json:"revert"`
+	} `yaml:"verify" json:"verify"`
+
+	// Validate generates the messages of the binding rules of the request
+	// structs, in zh and en, and ValidateErrMsg translating binding errors
+	// by Accept-Language into File. Handlers report binding errors with it.
+	Validate struct {
+		File string `yaml:"file" json:"file"`
+	} `yaml:"validate" json:"validate"`
+
+	// Proto configures the proto command, converting the annotated apis into
+	// a proto service per @group.
+	Proto struct {
+		Dir       string `yaml:"dir" json:"dir"`             // output directory, proto by default
+		Package   string `yaml:"package" json:"package"`     // proto package, the package of the type file by default
+		GoPackage string `yaml:"goPackage" json:"goPackage"` // go_package option
+		Lock      string `yaml:"lock" json:"lock"`           // lock file of the field numbers, <dir>/proto.lock by default
+	} `yaml:"proto" json:"proto"`
+
+	// GRPC generates a gRPC server adapter into File, with a method per api
+	// calling its logic function, and the conversion functions between the
+	// Go structs and the messages of the proto command into convert.go next
+	// to it. Proto.GoPackage must name the package of the generated messages.
+	GRPC struct {
+		File string `yaml:"file" json:"file"`
+	} `yaml:"grpc" json:"grpc"`
+
+	// Docs configures the docs-md command, rendering the annotated apis as a
+	// Markdown API reference.
+	Docs struct {
+		File      string `yaml:"file" json:"file"`           // output file, docs/api.md by default
+		Templates string `yaml:"templates" json:"templates"` // directory of *.tmpl files redefining the templates
+	} `yaml:"docs" json:"docs"`
+
+	// Stages enables or disables the stages of the pipeline by name, like
+	// router: false. Stages are enabled unless set to false.
+	Stages map[string]bool `yaml:"stages" json:"stages"`
+
+	// Plugins are executables run after the built-in stages of each api,
+	// see PluginRequest.
+	Plugins []Plugin `yaml:"plugins" json:"plugins"`
+
+	// Middleware is the registry resolving middleware names used by @auth
+	// and @middleware annotations.
+	Middleware map[string]Middleware `yaml:"middleware" json:"middleware"`
+
+	// mountPath is the absolute path the router function is mounted at,
+	// resolved from the module before generation.
+	mountPath string
+}
+
+// Middleware describes how a named middleware is referenced in the router file.
+type Middleware struct {
+	Expr   string `yaml:"expr" json:"expr"`     // e.g. middleware.JwtMiddleware()
+	Import string `yaml:"import" json:"import"` // import path of the package used by Expr
 }
 
 type APIGenBuilder struct {
@@ -32,51 +99,236 @@ type APIGenBuilder struct {
 	logicFunc   FuncInfo
 	handlerFunc FuncInfo
 	api         string
+	routes      *RouteSet
+	tx          *Tx
+	chain       *HandlerChain
+	model       *Model
+	err         error
+
+	// baseline holds the type errors of each touched package, by directory,
+	// before the apis changed it, so that only new errors are reported.
+	baseline map[string]map[string]string
 }
 
 func NewAPIGenBuilder() *APIGenBuilder {
 	return &APIGenBuilder{}
 }
 
-func (b *APIGenBuilder) WithConfig(configFile string) *APIGenBuilder {
+// LoadConfig reads and validates the config file.
+func LoadConfig(configFile string) (cfg Config, err error) {
 	configData, err := os.ReadFile(configFile)
 	if err != nil {
-		log.Fatalf("failed to read config file: %v", err)
+		return cfg, errors.Wrap(err, "failed to read config file")
 	}
 
-	if err := yaml.Unmarshal(configData, &b.cfg); err != nil {
-		log.Fatalf("failed to parse config file: %v", err)
+	if err := yaml.Unmarshal(configData, &cfg); err != nil {
+		return cfg, errors.Wrap(err, "failed to parse config file")
+	}
+	if cfg.Handler.Receiver != "" && cfg.Logic.Receiver == "" {
+		return cfg, errors.New("handler.receiver requires logic.receiver to be set")
 	}
 
+	return cfg, nil
+}
+
+func (b *APIGenBuilder) WithConfig(configFile string) *APIGenBuilder {
+	cfg, err := LoadConfig(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b.cfg = cfg
+
 	return b
 }
 
+// WithChain sets the pipeline run by Generate for each api. It defaults to
+// NewHandlerChain().
+func (b *APIGenBuilder) WithChain(chain *HandlerChain) *APIGenBuilder {
+	b.chain = chain
+	return b
+}
+
+// Config returns the config of the builder.
+func (b *APIGenBuilder) Config() Config { return b.cfg }
+
+// API returns the path of the api being generated.
+func (b *APIGenBuilder) API() string { return b.api }
+
+// Model returns the model of the type file, set by the types stage.
+func (b *APIGenBuilder) Model() *Model { return b.model }
+
+// TypeInfo returns the parsed api, set by the types stage.
+func (b *APIGenBuilder) TypeInfo() TypeInfo { return b.typeInfo }
+
+// LogicFunc returns the logic function, set by the logic stage.
+func (b *APIGenBuilder) LogicFunc() FuncInfo { return b.logicFunc }
+
+// HandlerFunc returns the handler function, set by the handler stage.
+func (b *APIGenBuilder) HandlerFunc() FuncInfo { return b.handlerFunc }
+
+// Tx returns the transaction of the api being generated. Stages write their
+// files through it.
+func (b *APIGenBuilder) Tx() *Tx { return b.tx }
+
+// WithTypeInfo parses the api of the given path from the type file. The
+// model of the type file is parsed once and reused for the following apis.
+// The following steps are skipped once a step has failed, and AddRouter
+// reports the error.
 func (b *APIGenBuilder) WithTypeInfo(typeFile, apiPath string) *APIGenBuilder {
-	b.typeInfo = parseTypes(typeFile, apiPath)
+	b.err, b.api = nil, apiPath
+	if b.tx == nil {
+		b.tx = NewTx()
+	}
+	if b.model == nil || b.model.File != typeFile {
+		if b.model, b.err = ParseModel(typeFile); b.err != nil {
+			return b
+		}
+	}
+	if api, ok := b.model.API(apiPath); ok {
+		b.typeInfo = api.TypeInfo(b.model.Package)
+		return b
+	}
+	b.err = errors.Errorf("api %s is not annotated in %s", apiPath, typeFile)
 	return b
 }
 
 func (b *APIGenBuilder) WithLogicFunc(logicFile string) *APIGenBuilder {
-	b.logicFunc = genLogicFunc(logicFile, b.typeInfo)
+	if b.err != nil {
+		return b
+	}
+	cfg := b.cfg
+	cfg.Logic.File = logicFile
+	b.logicFunc, b.err = genLogicFunc(b.tx, cfg, b.typeInfo)
 	return b
 }
 
 func (b *APIGenBuilder) WithHandlerFunc(handlerFile string) *APIGenBuilder {
-	b.handlerFunc = genHandlerFunc(handlerFile, b.typeInfo, b.logicFunc, b.cfg)
+	if b.err != nil {
+		return b
+	}
+	cfg := b.cfg
+	cfg.Handler.File = handlerFile
+	b.handlerFunc, b.err = genHandlerFunc(b.tx, b.typeInfo, b.logicFunc, cfg)
 	return b
 }
 
+// AddRouter adds the route of the api and writes the changes of the steps
+// together. Nothing is written when a step has failed.
 func (b *APIGenBuilder) AddRouter(routerFile, groupFunc string) error {
-	return addRouter(routerFile, groupFunc, b.typeInfo, b.handlerFunc)
+	if b.err == nil {
+		cfg := b.cfg
+		cfg.Router.File, cfg.Router.GroupFunc = routerFile, groupFunc
+		b.err = addRouter(b.tx, cfg, b.typeInfo, b.logicFunc, b.handlerFunc, b.routes)
+	}
+	if b.err != nil {
+		b.tx.Rollback()
+		return b.err
+	}
+	return b.tx.Commit()
+}
+
+// WithModule analyzes the module of the router file. It resolves the absolute
+// mount path of the router function so that generated annotations carry the
+// full URL, and collects the registered routes so that conflicting routes
+// are refused.
+func (b *APIGenBuilder) WithModule() *APIGenBuilder {
+	b.cfg.mountPath, b.routes = "/", nil
+
+	m, err := AnalyzeModule(filepath.Dir(b.cfg.Router.File))
+	if err != nil {
+		logrus.Warningf("Failed to analyze the routes of the module: %v", err)
+		return b
+	}
+	if fn, err := m.routerFunc(b.cfg); err == nil {
+		b.cfg.mountPath = fn.mountPath()
+	}
+
+	var conflicts []Conflict
+	b.routes, conflicts = NewRouteSet(m.Routes())
+	for _, c := range conflicts {
+		logrus.Warning(c)
+	}
+	return b
+}
+
+// Generate runs the pipeline for the api with the given path. The files of
+// all stages are written together once every stage succeeded, and left
+// untouched otherwise.
+func (b *APIGenBuilder) Generate(api string) error {
+	if b.chain == nil {
+		b.chain = NewHandlerChain()
+	}
+	b.api, b.err, b.tx = api, nil, NewTx()
+	b.typeInfo, b.logicFunc, b.handlerFunc = TypeInfo{}, FuncInfo{}, FuncInfo{}
+
+	err := b.chain.Run(b)
+	if err != nil {
+		b.tx.Rollback()
+		return err
+	}
+	if !b.cfg.Verify.Enabled {
+		return b.tx.Commit()
+	}
+
+	files := b.tx.Files()
+	if err := b.tx.Format(); err != nil {
+		b.tx.Rollback()
+		return err
+	}
+	if err := b.checkBaseline(files); err != nil {
+		b.tx.Rollback()
+		return errors.Wrap(err, "failed to type-check the packages before generation")
+	}
+	if err := b.tx.Commit(); err != nil {
+		return err
+	}
+
+	errs, err := verifyFiles(api, files, b.baseline)
+	if verr, ok := err.(*VerifyError); ok && b.cfg.Verify.Revert {
+		if rerr := b.tx.Revert(); rerr != nil {
+			return errors.Wrapf(rerr, "failed to revert api %s after: %v", api, err)
+		}
+		verr.Reverted = true
+		return err
+	}
+	// 未回滚时，已报告的错误不再计入后续 api
+	for dir, pkgErrs := range errs {
+		b.baseline[dir] = pkgErrs
+	}
+	return err
+}
+
+// checkBaseline type-checks the packages of the files that no api has
+// touched yet, before the staged changes are written, and records their
+// errors in the baseline.
+func (b *APIGenBuilder) checkBaseline(files []string) error {
+	if b.baseline == nil {
+		b.baseline = map[string]map[string]string{}
+	}
+	var unseen []string
+	for _, file := range files {
+		dir, err := filepath.Abs(filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+		if _, ok := b.baseline[dir]; !ok && filepath.Ext(file) == ".go" {
+			unseen = append(unseen, file)
+		}
+	}
+	errs, err := typeErrors(unseen)
+	if err != nil {
+		return err
+	}
+	for dir, pkgErrs := range errs {
+		b.baseline[dir] = pkgErrs
+	}
+	return nil
 }
 
 func (b *APIGenBuilder) Build() {
+	b.WithModule()
 	for _, api := range b.cfg.ApiPath {
-		err := b.WithTypeInfo(b.cfg.TypeFile, api).
-			WithLogicFunc(b.cfg.Logic.File).
-			WithHandlerFunc(b.cfg.Handler.File).
-			AddRouter(b.cfg.Router.File, b.cfg.Router.GroupFunc)
-		if err != nil {
+		if err := b.Generate(api); err != nil {
 			log.Fatal(err)
 		}
 	}
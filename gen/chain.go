@@ -1,131 +1,231 @@
 package gen
 
 import (
-	"flag"
-	"log"
-	"os"
-
-	"gopkg.in/yaml.v2"
+	"github.com/pkg/errors"
 )
 
-// 定义处理链的接口
-type HandlerChain interface {
-	SetNext(HandlerChain) HandlerChain // 设置下一个处理者
-	Handle(*APIGenBuilder)             // 处理请求
+// Stage is a step of the generation of an api. Stages read and update the
+// state of the builder, and write files through its transaction so that
+// their changes are committed together with the rest of the api.
+type Stage interface {
+	Name() string
+	Handle(*APIGenBuilder) error
 }
 
-// 解析类型的处理者
-type ParseTypesHandler struct {
-	next HandlerChain
+type stageFunc struct {
+	name string
+	fn   func(*APIGenBuilder) error
 }
 
-func (h *ParseTypesHandler) SetNext(next HandlerChain) HandlerChain {
-	h.next = next
-	return next
+func (s stageFunc) Name() string                  { return s.name }
+func (s stageFunc) Handle(b *APIGenBuilder) error { return s.fn(b) }
+
+// Resolver is implemented by stages whose results later stages use. When
+// such a stage is disabled, Resolve reads its results from the existing code
+// instead of generating it.
+type Resolver interface {
+	Resolve(*APIGenBuilder) error
 }
 
-func (h *ParseTypesHandler) Handle(data *APIGenBuilder) {
-	// 解析类型的逻辑
-	data.typeInfo = parseTypes(data.cfg.TypeFile, data.api)
+// StageFunc returns a stage running fn.
+func StageFunc(name string, fn func(*APIGenBuilder) error) Stage {
+	return stageFunc{name: name, fn: fn}
+}
 
-	// 调用下一个处理者
-	if h.next != nil {
-		h.next.Handle(data)
-	}
+// Names of the built-in stages.
+const (
+	StageTypes    = "types"
+	StageValidate = "validate"
+	StageLogic    = "logic"
+	StageHandler  = "handler"
+	StageGRPC     = "grpc"
+	StageRouter   = "router"
+)
+
+// HandlerChain is the pipeline generating an api. It runs its stages in
+// order, skipping the ones disabled by the stages section of the config.
+type HandlerChain struct {
+	stages []Stage
 }
 
-// 生成逻辑函数的处理者
-type GenLogicFuncHandler struct {
-	next HandlerChain
+// NewHandlerChain returns the chain of the built-in stages: parsing the
+// types, generating the validation messages, the logic and handler
+// functions and the gRPC adapter, and adding the route.
+func NewHandlerChain() *HandlerChain {
+	return &HandlerChain{stages: []Stage{
+		&ParseTypesHandler{},
+		&GenValidateHandler{},
+		&GenLogicFuncHandler{},
+		&GenHandlerFuncHandler{},
+		&GenGRPCHandler{},
+		&AddRouterHandler{},
+	}}
 }
 
-func (h *GenLogicFuncHandler) SetNext(next HandlerChain) HandlerChain {
-	h.next = next
-	return next
+// Stages returns the stages of the chain in order.
+func (c *HandlerChain) Stages() []Stage {
+	return append([]Stage(nil), c.stages...)
 }
 
-func (h *GenLogicFuncHandler) Handle(data *APIGenBuilder) {
-	// 生成逻辑函数的逻辑
-	data.logicFunc = genLogicFunc(data.cfg.Logic.File, data.typeInfo)
+// Use appends stages to the chain.
+func (c *HandlerChain) Use(stages ...Stage) *HandlerChain {
+	c.stages = append(c.stages, stages...)
+	return c
+}
 
-	// 调用下一个处理者
-	if h.next != nil {
-		h.next.Handle(data)
+// InsertBefore inserts the stages before the stage with the given name.
+func (c *HandlerChain) InsertBefore(name string, stages ...Stage) error {
+	i := c.index(name)
+	if i < 0 {
+		return errors.Errorf("stage %q not found", name)
 	}
+	c.stages = append(c.stages[:i], append(append([]Stage(nil), stages...), c.stages[i:]...)...)
+	return nil
 }
 
-// 生成处理函数的处理者
-type GenHandlerFuncHandler struct {
-	next HandlerChain
+// InsertAfter inserts the stages after the stage with the given name.
+func (c *HandlerChain) InsertAfter(name string, stages ...Stage) error {
+	i := c.index(name)
+	if i < 0 {
+		return errors.Errorf("stage %q not found", name)
+	}
+	c.stages = append(c.stages[:i+1], append(append([]Stage(nil), stages...), c.stages[i+1:]...)...)
+	return nil
 }
 
-func (h *GenHandlerFuncHandler) SetNext(next HandlerChain) HandlerChain {
-	h.next = next
-	return next
+// Remove removes the stage with the given name.
+func (c *HandlerChain) Remove(name string) error {
+	i := c.index(name)
+	if i < 0 {
+		return errors.Errorf("stage %q not found", name)
+	}
+	c.stages = append(c.stages[:i], c.stages[i+1:]...)
+	return nil
 }
 
-func (h *GenHandlerFuncHandler) Handle(data *APIGenBuilder) {
-	// 生成处理函数的逻辑
-	data.handlerFunc = genHandlerFunc(data.cfg.Handler.File, data.typeInfo, data.logicFunc, data.cfg)
+func (c *HandlerChain) index(name string) int {
+	for i, s := range c.stages {
+		if s.Name() == name {
+			return i
+		}
+	}
+	return -1
+}
 
-	// 调用下一个处理者
-	if h.next != nil {
-		h.next.Handle(data)
+// Run runs the enabled stages for the api of the builder, followed by the
+// plugins of the config, stopping at the first error.
+func (c *HandlerChain) Run(b *APIGenBuilder) error {
+	plugins, err := pluginStages(b.cfg)
+	if err != nil {
+		return err
+	}
+	stages := &HandlerChain{stages: append(c.Stages(), plugins...)}
+	names := map[string]bool{}
+	for _, s := range stages.stages {
+		if names[s.Name()] {
+			return errors.Errorf("duplicate stage %q", s.Name())
+		}
+		names[s.Name()] = true
+	}
+	for name := range b.cfg.Stages {
+		if !names[name] {
+			return errors.Errorf("unknown stage %q in config", name)
+		}
 	}
+
+	for _, s := range stages.stages {
+		if enabled, ok := b.cfg.Stages[s.Name()]; ok && !enabled {
+			if r, ok := s.(Resolver); ok {
+				if err := r.Resolve(b); err != nil {
+					return errors.Wrapf(err, "stage %s", s.Name())
+				}
+			}
+			continue
+		}
+		if err := s.Handle(b); err != nil {
+			return errors.Wrapf(err, "stage %s", s.Name())
+		}
+	}
+	return nil
 }
 
-// 添加路由的处理者
-type AddRouterHandler struct {
-	next HandlerChain
+// 解析类型的处理者
+type ParseTypesHandler struct{}
+
+func (h *ParseTypesHandler) Name() string { return StageTypes }
+
+func (h *ParseTypesHandler) Handle(b *APIGenBuilder) error {
+	return b.WithTypeInfo(b.cfg.TypeFile, b.api).err
 }
 
-func (h *AddRouterHandler) SetNext(next HandlerChain) HandlerChain {
-	h.next = next
-	return next
+// Resolve parses the types all the same, parsing writes nothing.
+func (h *ParseTypesHandler) Resolve(b *APIGenBuilder) error {
+	return h.Handle(b)
 }
 
-func (h *AddRouterHandler) Handle(data *APIGenBuilder) {
-	// 添加路由的逻辑
-	err := addRouter(data.cfg.Router.File, data.cfg.Router.GroupFunc, data.typeInfo, data.handlerFunc)
-	if err != nil {
-		log.Fatal(err)
+// 生成校验信息的处理者，未配置 validate.file 时跳过
+type GenValidateHandler struct{}
+
+func (h *GenValidateHandler) Name() string { return StageValidate }
+
+func (h *GenValidateHandler) Handle(b *APIGenBuilder) error {
+	if b.cfg.Validate.File == "" {
+		return nil
 	}
+	return genValidate(b.tx, b.cfg, b.model)
+}
 
-	// 不需要调用下一个处理者，这是处理链的最后一个处理者
+// 生成逻辑函数的处理者
+type GenLogicFuncHandler struct{}
+
+func (h *GenLogicFuncHandler) Name() string { return StageLogic }
+
+func (h *GenLogicFuncHandler) Handle(b *APIGenBuilder) error {
+	return b.WithLogicFunc(b.cfg.Logic.File).err
 }
 
-func loadConfig() Config {
-	var configFile string
-	flag.StringVar(&configFile, "c", "config.yaml", "path to config file")
-	flag.Parse()
+// Resolve finds the logic function already declared in the logic file.
+func (h *GenLogicFuncHandler) Resolve(b *APIGenBuilder) error {
+	b.logicFunc, b.err = resolveLogicFunc(b.tx, b.cfg, b.typeInfo)
+	return b.err
+}
 
-	configData, err := os.ReadFile(configFile)
-	if err != nil {
-		log.Fatalf("failed to read config file: %v", err)
-	}
+// 生成处理函数的处理者
+type GenHandlerFuncHandler struct{}
 
-	var cfg Config
-	if err := yaml.Unmarshal(configData, &cfg); err != nil {
-		log.Fatalf("failed to parse config file: %v", err)
-	}
-	return cfg
+func (h *GenHandlerFuncHandler) Name() string { return StageHandler }
+
+func (h *GenHandlerFuncHandler) Handle(b *APIGenBuilder) error {
+	return b.WithHandlerFunc(b.cfg.Handler.File).err
 }
 
-func main1() {
-	cfg := loadConfig()
+// Resolve finds the handler function already declared in the handler file.
+func (h *GenHandlerFuncHandler) Resolve(b *APIGenBuilder) error {
+	b.handlerFunc, b.err = resolveHandlerFunc(b.tx, b.cfg, b.typeInfo)
+	return b.err
+}
 
-	// 创建处理链
-	parseTypesHandler := &ParseTypesHandler{}
-	genLogicFuncHandler := &GenLogicFuncHandler{}
-	genHandlerFuncHandler := &GenHandlerFuncHandler{}
-	addRouterHandler := &AddRouterHandler{}
+// 生成 gRPC 适配器的处理者，未配置 grpc.file 时跳过
+type GenGRPCHandler struct{}
 
-	// 设置处理链的顺序
-	parseTypesHandler.SetNext(genLogicFuncHandler).SetNext(genHandlerFuncHandler).SetNext(addRouterHandler)
+func (h *GenGRPCHandler) Name() string { return StageGRPC }
 
-	for _, api := range cfg.ApiPath {
-		// 调用处理链的头部处理者
-		bd := &APIGenBuilder{cfg: cfg, api: api}
-		parseTypesHandler.Handle(bd)
+func (h *GenGRPCHandler) Handle(b *APIGenBuilder) error {
+	if b.cfg.GRPC.File == "" {
+		return nil
+	}
+	api, ok := b.model.API(b.api)
+	if !ok {
+		return errors.Errorf("api %s is not annotated in %s", b.api, b.cfg.TypeFile)
 	}
+	return genGRPC(b.tx, b.cfg, b.model, api)
+}
+
+// 添加路由的处理者
+type AddRouterHandler struct{}
+
+func (h *AddRouterHandler) Name() string { return StageRouter }
+
+func (h *AddRouterHandler) Handle(b *APIGenBuilder) error {
+	return addRouter(b.tx, b.cfg, b.typeInfo, b.logicFunc, b.handlerFunc, b.routes)
 }
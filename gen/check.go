@@ -0,0 +1,179 @@
+package gen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Issue is a mismatch found between the type file, the handler and logic
+// functions and the registered routes.
+type Issue struct {
+	API     string `json:"api,omitempty"` // router path of the api in the type file
+	Message string `json:"message"`
+}
+
+func (i Issue) String() string {
+	if i.API == "" {
+		return i.Message
+	}
+	return fmt.Sprintf("%s: %s", i.API, i.Message)
+}
+
+// funcDoc is a function declared in a handler or logic file.
+type funcDoc struct {
+	Name   string
+	Router string // path of the @Router annotation
+	Method string // method of the @Router annotation
+}
+
+// parseFuncDocs returns the package name and the functions of the Go file.
+func parseFuncDocs(filename string) (string, map[string]funcDoc, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		return "", nil, err
+	}
+
+	funcs := map[string]funcDoc{}
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		doc := funcDoc{Name: fn.Name.Name}
+		if fn.Doc != nil {
+			list := strings.Fields(fn.Doc.Text())
+			for i := 0; i+2 < len(list); i++ {
+				if list[i] == "@Router" {
+					doc.Router = list[i+1]
+					doc.Method = strings.ToUpper(strings.Trim(list[i+2], "[]"))
+				}
+			}
+		}
+		funcs[doc.Name] = doc
+	}
+	return file.Name.Name, funcs, nil
+}
+
+// Check cross-references the annotated apis of the type file with the
+// handler and logic functions and the routes registered by the router
+// function, and returns every mismatch found. Routes of the module that
+// gin would refuse to register are reported too.
+func Check(cfg Config) ([]Issue, error) {
+	apis, err := parseAllTypes(cfg.TypeFile)
+	if err != nil {
+		return nil, err
+	}
+	handlerPkg, handlers, err := parseFuncDocs(cfg.Handler.File)
+	if err != nil {
+		return nil, err
+	}
+	_, logics, err := parseFuncDocs(cfg.Logic.File)
+	if err != nil {
+		return nil, err
+	}
+	m, err := AnalyzeModule(filepath.Dir(cfg.Router.File))
+	if err != nil {
+		return nil, err
+	}
+	fn, err := m.routerFunc(cfg)
+	if err != nil {
+		return nil, err
+	}
+	mount := fn.mountPath()
+	routes := fn.Routes()
+
+	var issues []Issue
+	apiHandlers := map[string]bool{}
+	for _, api := range apis {
+		report := func(format string, args ...interface{}) {
+			issues = append(issues, Issue{API: api.Path, Message: fmt.Sprintf(format, args...)})
+		}
+
+		handlerName := api.HandlerName + "Handler"
+		logicName := api.HandlerName + "Logic"
+		apiHandlers[handlerName] = true
+		if _, ok := logics[logicName]; !ok {
+			report("logic %s is missing in %s", logicName, cfg.Logic.File)
+		}
+
+		group, err := getGroupPath(cfg.Router.File, cfg.Router.GroupFunc, api.Group)
+		if err != nil {
+			report("%v", err)
+			continue
+		}
+		want := joinPath(mount, group, api.Path)
+
+		handler, ok := handlers[handlerName]
+		if !ok {
+			report("handler %s is missing in %s", handlerName, cfg.Handler.File)
+		} else if handler.Router != "" {
+			if got := joinPath(cfg.Router.BasePath, handler.Router); got != want || handler.Method != api.Method {
+				report("@Router of %s is %s [%s], expected %s [%s]", handlerName, handler.Router, strings.ToLower(handler.Method),
+					trimBasePath(want, cfg.Router.BasePath), strings.ToLower(api.Method))
+			}
+		}
+
+		var registered []string
+		found := false
+		for _, r := range routes {
+			if handlerFuncName(r.Handler) != handlerName {
+				continue
+			}
+			registered = append(registered, r.Method+" "+r.Path)
+			if r.Method == api.Method && r.Path == want {
+				found = true
+			}
+		}
+		switch {
+		case len(registered) == 0:
+			report("no route registers %s, expected %s %s", handlerName, api.Method, want)
+		case !found:
+			report("%s is registered at %s, expected %s %s", handlerName, strings.Join(registered, ", "), api.Method, want)
+		}
+	}
+
+	handlerVar := handlerVarName(cfg.Handler.Receiver)
+	for _, r := range routes {
+		if r.Handler == "" {
+			issues = append(issues, Issue{Message: fmt.Sprintf("route %s %s at %s has no handler", r.Method, r.Path, r.Pos)})
+			continue
+		}
+		qualifier := strings.TrimSuffix(r.Handler, "."+handlerFuncName(r.Handler))
+		if qualifier != handlerPkg && qualifier != handlerVar {
+			continue
+		}
+		if _, ok := handlers[handlerFuncName(r.Handler)]; !ok {
+			issues = append(issues, Issue{Message: fmt.Sprintf("route %s %s at %s points at missing handler %s", r.Method, r.Path, r.Pos, r.Handler)})
+		}
+	}
+
+	_, conflicts := NewRouteSet(m.Routes())
+	for _, c := range conflicts {
+		issues = append(issues, Issue{Message: c.String()})
+	}
+
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if handler := handlers[name]; handler.Router != "" && !apiHandlers[name] {
+			issues = append(issues, Issue{Message: fmt.Sprintf("handler %s has no annotated api in %s", handler.Name, cfg.TypeFile)})
+		}
+	}
+
+	return issues, nil
+}
+
+// handlerFuncName returns the function name of a handler expression like
+// handler.LoginHandler.
+func handlerFuncName(handler string) string {
+	return handler[strings.LastIndex(handler, ".")+1:]
+}
@@ -0,0 +1,121 @@
+package gen
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// anyMethods are the methods registered by RouterGroup.Any.
+var anyMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodHead, http.MethodOptions, http.MethodDelete, http.MethodConnect, http.MethodTrace,
+}
+
+// Conflict is a route gin refuses to register.
+type Conflict struct {
+	Route    Route  `json:"route"`
+	Existing *Route `json:"existing,omitempty"` // nil when the route is invalid by itself
+	Reason   string `json:"reason"`
+}
+
+func (c Conflict) String() string {
+	if c.Existing == nil {
+		return fmt.Sprintf("route %s %s is invalid: %s", c.Route.Method, c.Route.Path, c.Reason)
+	}
+	existing := c.Existing.Pos
+	if c.Existing.Handler != "" {
+		existing = c.Existing.Handler + ", " + existing
+	}
+	return fmt.Sprintf("route %s %s conflicts with %s %s (%s): %s", c.Route.Method, c.Route.Path,
+		c.Existing.Method, c.Existing.Path, existing, c.Reason)
+}
+
+// RouteSet simulates the registration of routes in gin's radix trees, so
+// that conflicting routes are found before gin panics at startup.
+type RouteSet struct {
+	routes []Route
+}
+
+// NewRouteSet registers the routes in order and returns the set along with
+// the conflicts among them.
+func NewRouteSet(routes []Route) (*RouteSet, []Conflict) {
+	s := &RouteSet{}
+	var conflicts []Conflict
+	for _, r := range routes {
+		if c := s.Conflicts(r); len(c) > 0 {
+			conflicts = append(conflicts, c...)
+			continue
+		}
+		s.routes = append(s.routes, r)
+	}
+	return s, conflicts
+}
+
+// Add registers the route, or returns an error explaining every conflict
+// with the routes of the set.
+func (s *RouteSet) Add(r Route) error {
+	conflicts := s.Conflicts(r)
+	if len(conflicts) == 0 {
+		s.routes = append(s.routes, r)
+		return nil
+	}
+
+	msg := fmt.Sprintf("refusing to register %s %s", r.Method, r.Path)
+	for _, c := range conflicts {
+		msg += "\n\t" + c.String()
+	}
+	return errors.New(msg)
+}
+
+// Conflicts returns the conflicts of the route with the routes of the set.
+// Each conflicting route is found by registering it together with r in a
+// fresh gin engine, the whole set is replayed to catch the rest.
+func (s *RouteSet) Conflicts(r Route) []Conflict {
+	if reason := registerRoutes(r); reason != "" {
+		return []Conflict{{Route: r, Reason: reason}}
+	}
+
+	var conflicts []Conflict
+	for i := range s.routes {
+		existing := s.routes[i]
+		if reason := registerRoutes(existing, r); reason != "" {
+			conflicts = append(conflicts, Conflict{Route: r, Existing: &existing, Reason: reason})
+		}
+	}
+	if len(conflicts) == 0 {
+		if reason := registerRoutes(append(append([]Route{}, s.routes...), r)...); reason != "" {
+			conflicts = append(conflicts, Conflict{Route: r, Reason: reason})
+		}
+	}
+	return conflicts
+}
+
+// registerRoutes registers the routes in a new gin engine and returns the
+// reason of the panic gin raises, if any.
+func registerRoutes(routes ...Route) (reason string) {
+	mode := gin.Mode()
+	gin.SetMode(gin.ReleaseMode)
+	defer gin.SetMode(mode)
+
+	defer func() {
+		if err := recover(); err != nil {
+			reason = fmt.Sprint(err)
+		}
+	}()
+
+	engine := gin.New()
+	noop := func(*gin.Context) {}
+	for _, r := range routes {
+		methods := []string{r.Method}
+		if r.Method == "ANY" {
+			methods = anyMethods
+		}
+		for _, method := range methods {
+			engine.Handle(method, r.Path, noop)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package gen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRouteSetConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		routes   []Route
+		route    Route
+		existing string // path of the conflicting route, empty when the route is invalid by itself
+		reason   string // part of gin's reason, empty when the route is accepted
+	}{
+		{
+			name:   "different methods",
+			routes: []Route{{Method: "GET", Path: "/users/:id"}},
+			route:  Route{Method: "POST", Path: "/users/:name"},
+		},
+		{
+			name:   "static next to param",
+			routes: []Route{{Method: "GET", Path: "/users/:id"}},
+			route:  Route{Method: "GET", Path: "/users/me"},
+		},
+		{
+			name:     "wildcards with different names",
+			routes:   []Route{{Method: "GET", Path: "/users/:id"}},
+			route:    Route{Method: "GET", Path: "/users/:name"},
+			existing: "/users/:id",
+			reason:   "conflicts with existing wildcard",
+		},
+		{
+			name:     "duplicate route",
+			routes:   []Route{{Method: "GET", Path: "/login"}, {Method: "GET", Path: "/logout"}},
+			route:    Route{Method: "GET", Path: "/login"},
+			existing: "/login",
+			reason:   "handlers are already registered",
+		},
+		{
+			name:     "catch-all next to static paths",
+			routes:   []Route{{Method: "GET", Path: "/files/list"}},
+			route:    Route{Method: "GET", Path: "/files/*path"},
+			existing: "/files/list",
+			reason:   "catch-all",
+		},
+		{
+			name:     "any conflicts with every method",
+			routes:   []Route{{Method: "DELETE", Path: "/items/:id"}},
+			route:    Route{Method: "ANY", Path: "/items/:key"},
+			existing: "/items/:id",
+			reason:   "conflicts with existing wildcard",
+		},
+		{
+			name:   "invalid path",
+			route:  Route{Method: "GET", Path: "/files/*path/raw"},
+			reason: "catch-all routes are only allowed at the end of the path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, conflicts := NewRouteSet(tt.routes)
+			if len(conflicts) > 0 {
+				t.Fatalf("NewRouteSet() conflicts = %v", conflicts)
+			}
+			conflicts = set.Conflicts(tt.route)
+			err := set.Add(tt.route)
+			if tt.reason == "" {
+				if len(conflicts) > 0 || err != nil {
+					t.Fatalf("Conflicts() = %v, Add() = %v, want none", conflicts, err)
+				}
+				return
+			}
+			if len(conflicts) != 1 {
+				t.Fatalf("Conflicts() = %v, want one conflict", conflicts)
+			}
+			c := conflicts[0]
+			if !strings.Contains(c.Reason, tt.reason) {
+				t.Errorf("reason = %q, want it to contain %q", c.Reason, tt.reason)
+			}
+			switch {
+			case tt.existing == "" && c.Existing != nil:
+				t.Errorf("existing = %v, want none", c.Existing)
+			case tt.existing != "" && (c.Existing == nil || c.Existing.Path != tt.existing):
+				t.Errorf("existing = %v, want %s", c.Existing, tt.existing)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("Add() = %v, want an error containing %q", err, tt.reason)
+			}
+		})
+	}
+}
+
+func TestNewRouteSetSkipsConflicts(t *testing.T) {
+	set, conflicts := NewRouteSet([]Route{
+		{Method: "GET", Path: "/users/:id"},
+		{Method: "GET", Path: "/users/:name"},
+		{Method: "GET", Path: "/users/:id/posts"},
+	})
+	if len(conflicts) != 1 || conflicts[0].Route.Path != "/users/:name" {
+		t.Fatalf("conflicts = %v, want /users/:name", conflicts)
+	}
+	if len(set.routes) != 2 {
+		t.Errorf("routes = %v, want the two valid routes", set.routes)
+	}
+}
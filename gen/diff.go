@@ -0,0 +1,311 @@
+package gen
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Kinds of the changes between two versions of the type file.
+const (
+	ChangeAPIAdded      = "api-added"
+	ChangeAPIRemoved    = "api-removed"
+	ChangePathChanged   = "path-changed"
+	ChangeMethodChanged = "method-changed"
+	ChangeAuthAdded     = "auth-added"
+	ChangeAuthRemoved   = "auth-removed"
+	ChangeBodyChanged   = "body-changed"
+	ChangeFieldAdded    = "field-added"
+	ChangeFieldRemoved  = "field-removed"
+	ChangeFieldRenamed  = "field-renamed"
+	ChangeFieldMoved    = "field-moved"
+	ChangeTypeChanged   = "type-changed"
+	ChangeFieldRequired = "field-required"
+	ChangeFieldOptional = "field-optional"
+)
+
+// Change is a difference of an api between two versions of the type file.
+// Breaking changes are those that break existing clients.
+type Change struct {
+	API      string `json:"api"`             // method and path of the api, like POST /login
+	Kind     string `json:"kind"`            // one of the Change constants
+	Field    string `json:"field,omitempty"` // like request.addr.city
+	Message  string `json:"message"`
+	Breaking bool   `json:"breaking"`
+	Approved bool   `json:"approved,omitempty"` // listed in the approval file
+}
+
+// Key identifies the change in approval files, like
+// "POST /login field-removed response.user".
+func (c Change) Key() string {
+	return strings.TrimSpace(c.API + " " + c.Kind + " " + c.Field)
+}
+
+func (c Change) String() string {
+	level := "non-breaking"
+	switch {
+	case c.Breaking && c.Approved:
+		level = "breaking (approved)"
+	case c.Breaking:
+		level = "breaking"
+	}
+	return fmt.Sprintf("%s: %s [%s]", c.API, c.Message, level)
+}
+
+// Diff compares the annotated apis of the type file of the config with
+// those of base, a file or a git revision of the type file. Types declared
+// outside the type file are resolved from the working tree for both
+// versions.
+func Diff(cfg Config, base string) ([]Change, error) {
+	src, err := baseSource(cfg.TypeFile, base)
+	if err != nil {
+		return nil, err
+	}
+	old, err := ParseModelSource(cfg.TypeFile, src)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the base %s", base)
+	}
+	cur, err := ParseModel(cfg.TypeFile)
+	if err != nil {
+		return nil, err
+	}
+	return DiffModels(old, cur), nil
+}
+
+// baseSource reads base as a file, or else as a git revision of the type
+// file.
+func baseSource(typeFile, base string) ([]byte, error) {
+	if info, err := os.Stat(base); err == nil && !info.IsDir() {
+		return os.ReadFile(base)
+	}
+	cmd := exec.Command("git", "show", base+":./"+filepath.Base(typeFile))
+	cmd.Dir = filepath.Dir(typeFile)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Errorf("failed to read %s at %s: %s", typeFile, base, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// ApproveChanges marks the breaking changes whose keys are listed in the
+// approval file, one per line. Empty lines and lines starting with # are
+// ignored.
+func ApproveChanges(changes []Change, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	approved := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			approved[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for i := range changes {
+		changes[i].Approved = changes[i].Breaking && approved[changes[i].Key()]
+	}
+	return nil
+}
+
+// DiffModels compares two versions of the model. Apis are matched by their
+// handler, then by method and path, so that moved and renamed apis are
+// reported as such.
+func DiffModels(base, head *Model) []Change {
+	pairs := make([]int, len(head.APIs)) // index of the matching base api
+	used := make([]bool, len(base.APIs))
+	match := func(key func(API) string) {
+		index := map[string]int{}
+		for j, api := range base.APIs {
+			if _, ok := index[key(api)]; !used[j] && !ok {
+				index[key(api)] = j
+			}
+		}
+		for i, api := range head.APIs {
+			if j, ok := index[key(api)]; ok && pairs[i] < 0 && !used[j] {
+				pairs[i], used[j] = j, true
+			}
+		}
+	}
+	for i := range pairs {
+		pairs[i] = -1
+	}
+	match(func(api API) string { return api.Handler })
+	match(diffAPIName)
+
+	var changes []Change
+	for i, api := range head.APIs {
+		if pairs[i] < 0 {
+			changes = append(changes, Change{API: diffAPIName(api), Kind: ChangeAPIAdded, Message: "api added"})
+			continue
+		}
+		changes = append(changes, diffAPI(base.APIs[pairs[i]], api)...)
+	}
+	for j, api := range base.APIs {
+		if !used[j] {
+			changes = append(changes, Change{API: diffAPIName(api), Kind: ChangeAPIRemoved, Message: "api removed", Breaking: true})
+		}
+	}
+	return changes
+}
+
+// diffAPIName names an api by its method and its path in the group.
+func diffAPIName(api API) string {
+	return api.Method + " " + joinPath(api.Group, api.Path)
+}
+
+func diffAPI(base, head API) []Change {
+	var changes []Change
+	name := diffAPIName(head)
+	report := func(kind, field string, breaking bool, format string, args ...interface{}) {
+		changes = append(changes, Change{API: name, Kind: kind, Field: field, Message: fmt.Sprintf(format, args...), Breaking: breaking})
+	}
+
+	if from, to := joinPath(base.Group, base.Path), joinPath(head.Group, head.Path); from != to {
+		report(ChangePathChanged, "", true, "path changed from %s to %s", from, to)
+	}
+	if base.Method != head.Method {
+		report(ChangeMethodChanged, "", true, "method changed from %s to %s", base.Method, head.Method)
+	}
+	switch {
+	case !base.Auth && head.Auth:
+		report(ChangeAuthAdded, "", true, "authentication is now required")
+	case base.Auth && !head.Auth:
+		report(ChangeAuthRemoved, "", false, "authentication is no longer required")
+	}
+
+	for _, body := range []struct {
+		side               string
+		baseType, headType string
+		baseS, headS       *Schema
+	}{
+		{"request", base.RequestType, head.RequestType, base.Request, head.Request},
+		{"response", base.ResponseType, head.ResponseType, base.Response, head.Response},
+	} {
+		request := body.side == "request"
+		from, to := bodyKind(body.baseType, body.baseS), bodyKind(body.headType, body.headS)
+		if from != to {
+			// 去掉请求体不影响客户端，新增的请求体只在有必填字段时影响
+			breaking := from != "" && to != "" || !request && to == ""
+			report(ChangeBodyChanged, body.side, breaking, "%s body changed from %s to %s", body.side, orNone(body.baseType), orNone(body.headType))
+			if !request || from != "" {
+				continue
+			}
+		}
+		// 响应总是 JSON 的主体
+		baseIn, headIn := "body", "body"
+		if request {
+			baseIn, headIn = getParamType(base.Method), getParamType(head.Method)
+		}
+		var baseFields, headFields []docsField
+		if body.baseS != nil {
+			baseFields = docsFields(body.baseS, "", baseIn, body.baseType, 0)
+		}
+		if body.headS != nil {
+			headFields = docsFields(body.headS, "", headIn, body.headType, 0)
+		}
+		for _, c := range diffFields(body.side, baseFields, headFields, base.Method != head.Method) {
+			c.API = name
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// bodyKind returns the kind of a body: "" without body, array, object or
+// the type of other bodies.
+func bodyKind(typ string, s *Schema) string {
+	t := strings.TrimPrefix(typ, "*")
+	switch {
+	case t == "":
+		return ""
+	case strings.HasPrefix(t, "[") && t != "[]byte":
+		return "array"
+	case strings.HasPrefix(t, "map[") || s != nil:
+		return "object"
+	}
+	return t
+}
+
+func orNone(typ string) string {
+	if typ == "" {
+		return NoBody
+	}
+	return typ
+}
+
+// diffFields compares the fields of a request or response by their name on
+// the wire. A removed field whose Go field remains under another name is
+// reported as renamed. Removing a request field only makes the server ignore
+// it, while removing a response field breaks the clients reading it; new
+// required request fields break the clients not sending them.
+func diffFields(side string, base, head []docsField, methodChanged bool) []Change {
+	request := side == "request"
+	var changes []Change
+	report := func(kind string, f docsField, breaking bool, format string, args ...interface{}) {
+		changes = append(changes, Change{Kind: kind, Field: side + "." + f.Name, Message: fmt.Sprintf(format, args...), Breaking: breaking})
+	}
+
+	headByName := map[string]docsField{}
+	headByGo := map[string]docsField{}
+	for _, f := range head {
+		headByName[f.Name] = f
+		headByGo[f.goName] = f
+	}
+	baseByName := map[string]bool{}
+	for _, f := range base {
+		baseByName[f.Name] = true
+	}
+
+	renamed := map[string]bool{}
+	for _, bf := range base {
+		hf, ok := headByName[bf.Name]
+		if !ok {
+			if hf, ok := headByGo[bf.goName]; ok && !baseByName[hf.Name] {
+				renamed[hf.Name] = true
+				report(ChangeFieldRenamed, bf, true, "%s field %s renamed to %s", side, bf.Name, hf.Name)
+				continue
+			}
+			report(ChangeFieldRemoved, bf, !request, "%s field %s removed", side, bf.Name)
+			continue
+		}
+		if bf.kind != hf.kind {
+			report(ChangeTypeChanged, bf, true, "%s field %s changed type from %s to %s", side, bf.Name, bf.Type, hf.Type)
+		} else if bf.Type != hf.Type {
+			report(ChangeTypeChanged, bf, false, "%s field %s changed type from %s to %s, encoded the same way", side, bf.Name, bf.Type, hf.Type)
+		}
+		if request && bf.In != hf.In && !methodChanged {
+			report(ChangeFieldMoved, bf, true, "request field %s moved from %s to %s", bf.Name, bf.In, hf.In)
+		}
+		switch {
+		case request && !bf.Required && hf.Required:
+			report(ChangeFieldRequired, bf, true, "request field %s is now required", bf.Name)
+		case request && bf.Required && !hf.Required:
+			report(ChangeFieldOptional, bf, false, "request field %s is now optional", bf.Name)
+		}
+	}
+	for _, hf := range head {
+		if baseByName[hf.Name] || renamed[hf.Name] {
+			continue
+		}
+		if request && hf.Required {
+			report(ChangeFieldAdded, hf, true, "required request field %s added", hf.Name)
+		} else {
+			report(ChangeFieldAdded, hf, false, "%s field %s added", side, hf.Name)
+		}
+	}
+	return changes
+}
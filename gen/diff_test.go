@@ -0,0 +1,130 @@
+package gen
+
+import (
+	"reflect"
+	"testing"
+)
+
+// diffLogin returns a new login api, for tests to change a copy of it.
+func diffLogin() API {
+	return API{
+		Path:        "/login",
+		Method:      "POST",
+		Handler:     "Login",
+		RequestType: "LoginReq",
+		Request: &Schema{Name: "LoginReq", Fields: []Field{
+			{Name: "Name", Type: "string", Tags: map[string]string{"json": "name"}, Required: true},
+		}},
+		ResponseType: "LoginResp",
+		Response: &Schema{Name: "LoginResp", Fields: []Field{
+			{Name: "Token", Type: "string", Tags: map[string]string{"json": "token"}},
+			{Name: "Expire", Type: "int64", Tags: map[string]string{"json": "expire"}},
+		}},
+	}
+}
+
+func TestDiffModels(t *testing.T) {
+	tests := []struct {
+		name string
+		base func(api *API) // changes the base api, if any
+		head func(api *API)
+		want []string // keys of the changes, with ! for breaking ones
+	}{
+		{
+			name: "no change",
+			head: func(api *API) {},
+		},
+		{
+			name: "required request field added",
+			head: func(api *API) {
+				api.Request.Fields = append(api.Request.Fields, Field{Name: "Code", Type: "string", Tags: map[string]string{"json": "code"}, Required: true})
+			},
+			want: []string{"!POST /login field-added request.code"},
+		},
+		{
+			name: "optional request field added",
+			head: func(api *API) {
+				api.Request.Fields = append(api.Request.Fields, Field{Name: "Code", Type: "string", Tags: map[string]string{"json": "code"}})
+			},
+			want: []string{"POST /login field-added request.code"},
+		},
+		{
+			name: "response field removed",
+			head: func(api *API) {
+				api.Response.Fields = api.Response.Fields[1:]
+			},
+			want: []string{"!POST /login field-removed response.token"},
+		},
+		{
+			name: "field renamed on the wire keeps its Go name",
+			head: func(api *API) {
+				api.Response.Fields[0].Tags = map[string]string{"json": "access_token"}
+			},
+			want: []string{"!POST /login field-renamed response.token"},
+		},
+		{
+			name: "response body changed to a list",
+			head: func(api *API) {
+				api.ResponseType = "[]LoginResp"
+			},
+			want: []string{"!POST /login body-changed response"},
+		},
+		{
+			name: "auth added",
+			head: func(api *API) {
+				api.Auth = true
+			},
+			want: []string{"!POST /login auth-added"},
+		},
+		{
+			name: "handler renamed",
+			head: func(api *API) {
+				api.Handler = "SignIn"
+			},
+		},
+		{
+			name: "path changed",
+			head: func(api *API) {
+				api.Path = "/signin"
+			},
+			want: []string{"!POST /signin path-changed"},
+		},
+		{
+			name: "response field of a get api renamed",
+			base: func(api *API) {
+				api.Method = "GET"
+				api.Request.Fields[0].Tags = map[string]string{"form": "name"}
+				api.Response.Fields[0].Tags = map[string]string{"json": "token", "form": "t"}
+			},
+			head: func(api *API) {
+				api.Method = "GET"
+				api.Request.Fields[0].Tags = map[string]string{"form": "name"}
+				api.Response.Fields[0].Tags = map[string]string{"json": "access_token", "form": "t"}
+			},
+			want: []string{"!GET /login field-renamed response.token"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := diffLogin()
+			if tt.base != nil {
+				tt.base(&base)
+			}
+			head := diffLogin()
+			tt.head(&head)
+			changes := DiffModels(&Model{APIs: []API{base}}, &Model{APIs: []API{head}})
+
+			var got []string
+			for _, c := range changes {
+				key := c.Key()
+				if c.Breaking {
+					key = "!" + key
+				}
+				got = append(got, key)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffModels() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// docsTmp defines the templates of the Markdown reference. Templates of
+// docs.templates redefine them by name.
+var docsTmp = `{{ define "doc" -}}
+# {{ .Title }}
+{{ range .Groups }}
+{{ template "group" . }}
+{{- end }}
+{{- end }}
+
+{{- define "group" -}}
+## {{ .Name }}
+{{ range .APIs }}
+{{ template "api" . }}
+{{- end }}
+{{- end }}
+
+{{- define "api" -}}
+### {{ .Title }}
+
+` + "`{{ .Method }} {{ .FullPath }}`" + `
+{{ if .Comment }}
+{{ .Comment }}
+{{ end }}
+**Auth**: {{ if .Auth }}required, send the token in the ` + "`Authorization`" + ` header{{ else }}not required{{ end }}
+{{- if .Middlewares }}
+
+**Middlewares**: {{ range $i, $m := .Middlewares }}{{ if $i }}, {{ end }}` + "`{{ $m }}`" + `{{ end }}
+{{- end }}
+
+#### Request
+{{ template "request" .Request }}
+{{- if .RequestExample }}
+` + "```{{ .RequestLang }}" + `
+{{ .RequestExample }}
+` + "```" + `
+{{- end }}
+
+#### Response
+{{ template "response" .Response }}
+` + "```json" + `
+{{ .ResponseExample }}
+` + "```" + `
+{{ end }}
+
+{{- define "request" }}
+{{ if . -}}
+| Name | Location | Type | Required | Comment |
+| --- | --- | --- | --- | --- |
+{{ range . }}| {{ .Name }} | {{ .In }} | ` + "`{{ .Type }}`" + ` | {{ if .Required }}yes{{ else }}no{{ end }} | {{ cell .Comment }} |
+{{ end }}
+{{- else -}}
+No parameters.
+{{ end }}
+{{- end }}
+
+{{- define "response" }}
+{{ if . -}}
+The fields of ` + "`data`" + `:
+
+| Name | Type | Comment |
+| --- | --- | --- |
+{{ range . }}| {{ .Name }} | ` + "`{{ .Type }}`" + ` | {{ cell .Comment }} |
+{{ end }}
+{{- else -}}
+No data.
+{{ end }}
+{{- end }}
+`
+
+type docsData struct {
+	Title  string
+	Groups []*docsGroup
+}
+
+type docsGroup struct {
+	Name string
+	APIs []docsAPI
+}
+
+// docsAPI is an api as rendered by the templates.
+type docsAPI struct {
+	API
+	Title           string // summary, or handler name
+	FullPath        string
+	Request         []docsField
+	Response        []docsField
+	RequestExample  string // JSON body, or request line with the query
+	RequestLang     string // json or http
+	ResponseExample string // JSON in the util.Response envelope
+}
+
+// docsField is a field of a request or response, named by its dotted path
+// like addr.city, and tags[] for list elements.
+type docsField struct {
+	Name     string
+	In       string // path, header, query or body
+	Type     string
+	Required bool
+	Comment  string
+
+	goName string // Go name of the field after the path of its parent
+	kind   string // JSON kind, or the Go type of unknown named types
+}
+
+// GenDocs renders the annotated apis of the config as a Markdown API
+// reference into docs.file, with a section per @group and a subsection per
+// api, and returns the file. Templates are those of docsTmp, redefined by
+// the *.tmpl files of docs.templates.
+func GenDocs(cfg Config, seed int64) (string, error) {
+	m, err := ParseModel(cfg.TypeFile)
+	if err != nil {
+		return "", err
+	}
+	filename := cfg.Docs.File
+	if filename == "" {
+		filename = filepath.Join("docs", "api.md")
+	}
+	tmpl, err := texttemplate.New("docs").Funcs(texttemplate.FuncMap{
+		"cell": docsCell,
+	}).Parse(docsTmp)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Docs.Templates != "" {
+		if tmpl, err = tmpl.ParseGlob(filepath.Join(cfg.Docs.Templates, "*.tmpl")); err != nil {
+			return "", errors.Wrap(err, "failed to parse the docs templates")
+		}
+	}
+
+	data, err := docsModel(cfg, m, seed)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "doc", data); err != nil {
+		return "", err
+	}
+
+	tx := NewTx()
+	if old, err := tx.ReadFile(filename); err == nil && bytes.Equal(old, buf.Bytes()) {
+		return filename, nil
+	}
+	tx.WriteFile(filename, buf.Bytes())
+	fmt.Println("File", filename, "will be written.")
+	return filename, tx.Commit()
+}
+
+func docsModel(cfg Config, m *Model, seed int64) (*docsData, error) {
+	mount, err := routerMount(cfg)
+	if err != nil {
+		logrus.Warningf("Failed to analyze the routes of the module: %v", err)
+		mount = "/"
+	}
+
+	data := &docsData{Title: protoName(m.Package) + " API"}
+	groups := map[string]*docsGroup{}
+	for _, api := range m.APIs {
+		name := api.Group
+		if name == "" {
+			name = m.Package
+		}
+		group, ok := groups[name]
+		if !ok {
+			group = &docsGroup{Name: name}
+			groups[name] = group
+			data.Groups = append(data.Groups, group)
+		}
+
+		doc := docsAPI{API: api, Title: api.Summary, FullPath: apiPath(cfg, mount, api)}
+		if doc.Title == "" {
+			doc.Title = api.Handler
+		}
+		doc.Comment = strings.TrimSpace(api.Comment)
+
+		in := getParamType(api.Method)
+		if api.Request != nil {
+			doc.Request = docsFields(api.Request, "", in, api.RequestType, 0)
+		}
+		if api.Response != nil {
+			doc.Response = docsFields(api.Response, "", "body", api.ResponseType, 0)
+		}
+
+		if api.RequestType != "" {
+			if in == "query" {
+				query := queryString(queryParams(exampleGen{seed: seed, key: "form"}.body(api.RequestType, api.Request)))
+				doc.RequestExample = api.Method + " " + doc.FullPath + query
+				doc.RequestLang = "http"
+			} else {
+				b, err := json.MarshalIndent(exampleGen{seed: seed, key: "json"}.body(api.RequestType, api.Request), "", "  ")
+				if err != nil {
+					return nil, errors.Wrapf(err, "api %s: failed to marshal the example request", api.Path)
+				}
+				doc.RequestExample, doc.RequestLang = string(b), "json"
+			}
+		}
+		resp := exampleObject{{"code", 0}, {"msg", "操作成功"}, {"data", exampleGen{seed: seed, key: "json"}.body(api.ResponseType, api.Response)}}
+		b, err := json.MarshalIndent(resp, "", "  ")
+		if err != nil {
+			return nil, errors.Wrapf(err, "api %s: failed to marshal the example response", api.Path)
+		}
+		doc.ResponseExample = string(b)
+		group.APIs = append(group.APIs, doc)
+	}
+	return data, nil
+}
+
+// docsFields lists the fields of the schema of a body of type typ, and
+// those of its nested structs. Fields bound from the path or headers keep
+// their location; the others are in the query or the body. Structs nested
+// in the query are flattened, like the form binding of gin reads them.
+func docsFields(s *Schema, prefix, in, typ string, depth int) []docsField {
+	if depth > exampleMaxDepth {
+		return nil
+	}
+	// 列表类型的请求体以 [] 表示元素
+	if t := strings.TrimPrefix(typ, "*"); strings.HasPrefix(t, "[") || strings.HasPrefix(t, "map[") {
+		prefix += "[]."
+	}
+
+	var fields []docsField
+	for _, f := range s.Fields {
+		field := docsField{In: in, Type: f.Type, Required: f.Required, Comment: f.Comment, goName: prefix + f.Name, kind: jsonKind(f)}
+		key := "json"
+		if in == "query" {
+			key = "form"
+		}
+		for _, loc := range [][2]string{{"uri", "path"}, {"header", "header"}} {
+			if name, _, _ := strings.Cut(f.Tags[loc[0]], ","); name != "" && name != "-" {
+				key, field.In = loc[0], loc[1]
+				field.Required = field.Required || loc[1] == "path"
+			}
+		}
+		name, _, _ := strings.Cut(f.Tags[key], ",")
+		if name == "-" {
+			continue
+		}
+		if f.Embedded && name == "" && f.Schema != nil {
+			fields = append(fields, docsFields(f.Schema, prefix, in, f.Type, depth+1)...)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		field.Name = prefix + name
+		fields = append(fields, field)
+
+		if f.Schema != nil && !isExampleScalar(strings.TrimPrefix(f.Type, "*")) {
+			nested := field.Name + "."
+			if in == "query" && field.In == "query" {
+				nested = prefix
+			}
+			elem := strings.TrimPrefix(f.Type, "*")
+			if strings.HasPrefix(elem, "[") || strings.HasPrefix(elem, "map[") {
+				nested = strings.TrimSuffix(nested, ".") + "[]."
+				elem = ""
+			}
+			fields = append(fields, docsFields(f.Schema, nested, field.In, elem, depth+1)...)
+		}
+	}
+	return fields
+}
+
+// jsonKind returns the kind of JSON value a field is encoded into: string,
+// number, bool, array, object or any. Named types of unknown underlying
+// type are returned as is.
+func jsonKind(f Field) string {
+	typ := strings.TrimPrefix(f.Type, "*")
+	switch {
+	case typ == "[]byte" || typ == "[]uint8" || typ == "string" || typ == "time.Time":
+		return "string"
+	case typ == "bool":
+		return "bool"
+	case typ == "interface{}" || typ == "any":
+		return "any"
+	case strings.HasPrefix(typ, "["):
+		return "array"
+	case strings.HasPrefix(typ, "map[") || f.Schema != nil:
+		return "object"
+	case typ == "time.Duration":
+		return "number"
+	}
+	if scalar, ok := protoScalars[typ]; ok && scalar != "string" && scalar != "bool" {
+		return "number"
+	}
+	// 具名基础类型按其底层类型，没有类型信息时按枚举值推断
+	switch scalar, ok := protoScalars[f.Basic]; {
+	case scalar == "string" || scalar == "bool":
+		return scalar
+	case ok:
+		return "number"
+	}
+	for _, v := range f.Enum {
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return "string"
+		}
+	}
+	if len(f.Enum) > 0 {
+		return "number"
+	}
+	return typ
+}
+
+// docsCell escapes a comment for a table cell.
+func docsCell(s string) string {
+	s = strings.ReplaceAll(strings.TrimSpace(s), "|", `\|`)
+	return strings.Join(strings.Fields(strings.ReplaceAll(s, "\n", " ")), " ")
+}
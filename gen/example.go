@@ -0,0 +1,290 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exampleGen builds example values of the bodies of the apis. Values come
+// from the example tag of the fields, or are faked from their types and
+// names. Fakes only depend on the seed and the field, so the same seed
+// always gives the same examples.
+type exampleGen struct {
+	seed int64
+	key  string // tag naming the fields, json or form
+}
+
+// exampleObject is a JSON object keeping the order of the struct fields.
+type exampleObject []exampleProp
+
+type exampleProp struct {
+	Name  string
+	Value interface{}
+}
+
+func (o exampleObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, p := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(p.Name)
+		value, err := json.Marshal(p.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// 嵌套结构体的最大深度
+const exampleMaxDepth = 8
+
+// body returns an example of a body of type typ, nil when there is no body.
+func (g exampleGen) body(typ string, s *Schema) interface{} {
+	if typ == "" {
+		return nil
+	}
+	name := typ
+	if s != nil && s.Name != "" {
+		name = s.Name
+	}
+	return g.value(typ, Field{Type: typ, Schema: s}, name, 0)
+}
+
+// object returns an example of the fields of the schema. Embedded structs
+// without a name are flattened, like encoding/json does.
+func (g exampleGen) object(owner string, s *Schema, depth int) exampleObject {
+	obj := exampleObject{}
+	for _, f := range s.Fields {
+		name := g.fieldName(f)
+		if name == "-" {
+			continue
+		}
+		if f.Embedded && name == "" && f.Schema != nil {
+			obj = append(obj, g.object(owner, f.Schema, depth)...)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		obj = append(obj, exampleProp{Name: name, Value: g.field(owner+"."+f.Name, f, depth)})
+	}
+	return obj
+}
+
+// fieldName returns the name of the field in the tag of the generator, "-"
+// for ignored fields.
+func (g exampleGen) fieldName(f Field) string {
+	name, _, _ := strings.Cut(f.Tags[g.key], ",")
+	return name
+}
+
+// field returns the example of a field, from its example tag if set.
+func (g exampleGen) field(path string, f Field, depth int) interface{} {
+	if example, ok := f.Tags["example"]; ok {
+		return exampleTag(example, f.Type)
+	}
+	return g.value(f.Type, f, path, depth)
+}
+
+func (g exampleGen) value(typ string, f Field, path string, depth int) interface{} {
+	pointer := strings.HasPrefix(typ, "*")
+	typ = strings.TrimPrefix(typ, "*")
+	switch {
+	case typ == "[]byte" || typ == "[]uint8":
+		return "ZXhhbXBsZQ=="
+	case strings.HasPrefix(typ, "["):
+		if depth >= exampleMaxDepth {
+			return []interface{}{}
+		}
+		return []interface{}{g.value(elemType(typ), exampleElem(f), path, depth+1)}
+	case strings.HasPrefix(typ, "map["):
+		if depth >= exampleMaxDepth {
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{"key": g.value(elemType(typ), exampleElem(f), path, depth+1)}
+	case f.Schema != nil && !isExampleScalar(typ):
+		if depth >= exampleMaxDepth {
+			return nil
+		}
+		return g.object(typeBase(typ, f.Schema), f.Schema, depth+1)
+	}
+	if _, ok := protoScalars[typ]; pointer && !ok && len(f.Enum) == 0 && f.Basic == "" && !strings.HasPrefix(typ, "time.") {
+		// 未知类型的指针多为结构体的递归引用
+		return nil
+	}
+	return g.scalar(typ, f, path)
+}
+
+// scalar fakes a value of a basic type. Named basic types take one of their
+// enum values, or a value of their underlying type.
+func (g exampleGen) scalar(typ string, f Field, path string) interface{} {
+	h := g.hash(path)
+	rules := parseExampleRules(f.Tags["binding"])
+	if len(f.Enum) > 0 {
+		return exampleTag(f.Enum[h%uint64(len(f.Enum))], "")
+	}
+	if oneof, ok := rules["oneof"]; ok && oneof != "" {
+		options := strings.Fields(oneof)
+		return exampleTag(options[h%uint64(len(options))], typ)
+	}
+
+	switch typ {
+	case "string":
+		return fitLength(fakeString(path[strings.LastIndex(path, ".")+1:], h), rules)
+	case "bool":
+		return h%2 == 0
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "byte", "rune":
+		return int64(fitRange(float64(h%100+1), rules))
+	case "float32", "float64":
+		return fitRange(float64(h%10000)/100, rules)
+	case "time.Time":
+		return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(h%(365*24)) * time.Hour).Format(time.RFC3339)
+	case "time.Duration":
+		return int64(h%3600+1) * int64(time.Second)
+	case "interface{}", "any":
+		return nil
+	}
+	// 具名基础类型按其底层类型，底层类型未知的按字符串处理
+	if _, ok := protoScalars[f.Basic]; ok && f.Basic != typ {
+		return g.scalar(f.Basic, f, path)
+	}
+	return fakeString(path[strings.LastIndex(path, ".")+1:], h)
+}
+
+// hash returns the seed of the fakes of the field at path, like
+// LoginReq.Name.
+func (g exampleGen) hash(path string) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%s", g.seed, path)
+	return h.Sum64()
+}
+
+// fakeString fakes a string after the name of the field.
+func fakeString(name string, h uint64) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.Contains(lower, "email"):
+		return fmt.Sprintf("user%d@example.com", h%1000)
+	case strings.Contains(lower, "phone") || strings.Contains(lower, "mobile"):
+		return fmt.Sprintf("138%08d", h%100000000)
+	case strings.Contains(lower, "url") || strings.Contains(lower, "avatar") || strings.Contains(lower, "link"):
+		return fmt.Sprintf("https://example.com/%s/%d", lower, h%1000)
+	case strings.HasSuffix(lower, "id") || strings.Contains(lower, "uuid"):
+		return fmt.Sprintf("%016x", h)
+	case strings.Contains(lower, "password") || strings.Contains(lower, "secret"):
+		return fmt.Sprintf("P@ssw0rd%d", h%100)
+	case strings.Contains(lower, "time") || strings.Contains(lower, "date"):
+		return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(h%(365*24)) * time.Hour).Format(time.DateTime)
+	}
+	return fmt.Sprintf("%s_%d", lower, h%1000)
+}
+
+// exampleTag converts the value of an example tag according to the Go type
+// of the field. Lists are written as JSON or separated by commas; values
+// that do not convert are kept as strings.
+func exampleTag(value, typ string) interface{} {
+	typ = strings.TrimPrefix(typ, "*")
+	var v interface{}
+	switch {
+	case typ == "string":
+		return value
+	case strings.HasPrefix(typ, "[") && !strings.HasPrefix(value, "["):
+		items := []interface{}{}
+		for _, item := range strings.Split(value, ",") {
+			items = append(items, exampleTag(strings.TrimSpace(item), elemType(typ)))
+		}
+		return items
+	case json.Unmarshal([]byte(value), &v) == nil:
+		return v
+	}
+	return value
+}
+
+// parseExampleRules returns the binding rules with their parameters.
+func parseExampleRules(tag string) map[string]string {
+	rules := map[string]string{}
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if name == "dive" {
+			break
+		}
+		rules[name] = param
+	}
+	return rules
+}
+
+// exampleElem returns the field as seen by its elements, with the binding
+// rules after dive.
+func exampleElem(f Field) Field {
+	_, rules, _ := strings.Cut(f.Tags["binding"], "dive")
+	tags := map[string]string{"binding": strings.TrimPrefix(rules, ",")}
+	for k, v := range f.Tags {
+		if k != "binding" {
+			tags[k] = v
+		}
+	}
+	f.Tags = tags
+	return f
+}
+
+// fitLength pads or cuts s to the length rules.
+func fitLength(s string, rules map[string]string) string {
+	if n, ok := rules["len"]; ok {
+		rules = map[string]string{"min": n, "max": n}
+	}
+	if n, err := strconv.Atoi(rules["min"]); err == nil && len(s) < n {
+		s += strings.Repeat("x", n-len(s))
+	}
+	if n, err := strconv.Atoi(rules["max"]); err == nil && len(s) > n {
+		s = s[:n]
+	}
+	return s
+}
+
+// fitRange moves v into the range rules.
+func fitRange(v float64, rules map[string]string) float64 {
+	for _, name := range []string{"min", "gte", "gt"} {
+		if n, err := strconv.ParseFloat(rules[name], 64); err == nil && v < n {
+			v = n
+			if name == "gt" {
+				v++
+			}
+		}
+	}
+	for _, name := range []string{"max", "lte", "lt"} {
+		if n, err := strconv.ParseFloat(rules[name], 64); err == nil && v > n {
+			v = n
+			if name == "lt" {
+				v--
+			}
+		}
+	}
+	return v
+}
+
+// isExampleScalar reports whether the type is faked as a single value even
+// though it is a struct, like time.Time.
+func isExampleScalar(typ string) bool {
+	return typ == "time.Time"
+}
+
+// typeBase returns the name of the struct of a field type, the schema name
+// when it has one.
+func typeBase(typ string, s *Schema) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return typ[strings.LastIndex(typ, ".")+1:]
+}
@@ -0,0 +1,318 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ExportOptions configures the export of the annotated apis.
+type ExportOptions struct {
+	Format  string // postman or http
+	BaseURL string // value of the baseUrl variable
+	Seed    int64  // seed of the faked example values
+}
+
+// exportRequest is an api as a request of the collection.
+type exportRequest struct {
+	Group       string
+	Name        string
+	Handler     string
+	Description string
+	Method      string
+	Path        string
+	Params      [][2]string // path parameters with example values
+	Query       [][2]string
+	Body        string // indented JSON, empty for query parameters
+	Auth        bool
+}
+
+var postmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+// Export renders the annotated apis of the config as a Postman v2.1
+// collection or a .http file of the REST Client extension, with a folder
+// per @group. Requests take their parameters from examples of the request
+// types, in the query for GET and in a JSON body otherwise, and apis with
+// @auth send the token variable in the Authorization header.
+func Export(cfg Config, opts ExportOptions) ([]byte, error) {
+	m, err := ParseModel(cfg.TypeFile)
+	if err != nil {
+		return nil, err
+	}
+	reqs, err := exportRequests(cfg, m, opts.Seed)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BaseURL == "" {
+		opts.BaseURL = "http://localhost:8080"
+	}
+	switch opts.Format {
+	case "", "postman":
+		return postmanCollection(m.Package, opts.BaseURL, reqs)
+	case "http":
+		return httpFile(opts.BaseURL, reqs), nil
+	}
+	return nil, errors.Errorf("unknown export format %s", opts.Format)
+}
+
+func exportRequests(cfg Config, m *Model, seed int64) ([]exportRequest, error) {
+	mount, err := routerMount(cfg)
+	if err != nil {
+		logrus.Warningf("Failed to analyze the routes of the module: %v", err)
+		mount = "/"
+	}
+
+	var reqs []exportRequest
+	for _, api := range m.APIs {
+		req := exportRequest{
+			Group:       api.Group,
+			Name:        api.Summary,
+			Handler:     api.Handler,
+			Description: api.Comment,
+			Method:      api.Method,
+			Path:        apiPath(cfg, mount, api),
+			Auth:        api.Auth,
+		}
+		if req.Name == "" {
+			req.Name = api.Handler
+		}
+		if req.Method == "ANY" {
+			req.Method = "GET"
+		}
+
+		// 路径参数取 uri 标签字段的示例值
+		uri, _ := exampleGen{seed: seed, key: "uri"}.body(api.RequestType, api.Request).(exampleObject)
+		for _, param := range protoPathParam.FindAllStringSubmatch(req.Path, -1) {
+			value := ""
+			for _, p := range uri {
+				if p.Name == param[2] {
+					value = fmt.Sprint(p.Value)
+				}
+			}
+			req.Params = append(req.Params, [2]string{param[2], value})
+		}
+
+		if api.RequestType != "" {
+			if getParamType(api.Method) == "query" {
+				req.Query = queryParams(exampleGen{seed: seed, key: "form"}.body(api.RequestType, api.Request))
+			} else {
+				body, err := json.MarshalIndent(exampleGen{seed: seed, key: "json"}.body(api.RequestType, api.Request), "", "  ")
+				if err != nil {
+					return nil, errors.Wrapf(err, "api %s: failed to marshal the example request", api.Path)
+				}
+				req.Body = string(body)
+			}
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+// queryParams flattens an example into query parameters. Nested structs
+// are flattened like the form binding of gin reads them, and lists repeat
+// their key.
+func queryParams(v interface{}) [][2]string {
+	obj, ok := v.(exampleObject)
+	if !ok {
+		return nil
+	}
+	var params [][2]string
+	for _, p := range obj {
+		switch value := p.Value.(type) {
+		case nil:
+		case exampleObject:
+			params = append(params, queryParams(value)...)
+		case []interface{}:
+			for _, item := range value {
+				params = append(params, [2]string{p.Name, fmt.Sprint(item)})
+			}
+		case map[string]interface{}:
+			// map 参数 gin 按 name[key] 读取
+			keys := make([]string, 0, len(value))
+			for key := range value {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				params = append(params, [2]string{p.Name + "[" + key + "]", fmt.Sprint(value[key])})
+			}
+		default:
+			params = append(params, [2]string{p.Name, fmt.Sprint(value)})
+		}
+	}
+	return params
+}
+
+// exportGroups returns the requests by @group in the order of their first api.
+// Requests without @group come first under the empty group.
+func exportGroups(reqs []exportRequest) ([]string, map[string][]exportRequest) {
+	var names []string
+	groups := map[string][]exportRequest{}
+	for _, req := range reqs {
+		if _, ok := groups[req.Group]; !ok {
+			names = append(names, req.Group)
+		}
+		groups[req.Group] = append(groups[req.Group], req)
+	}
+	sort.SliceStable(names, func(i, j int) bool { return names[i] == "" && names[j] != "" })
+	return names, groups
+}
+
+type postmanItem struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Item        []postmanItem   `json:"item,omitempty"`
+	Request     *postmanRequest `json:"request,omitempty"`
+}
+
+type postmanRequest struct {
+	Method string         `json:"method"`
+	Header []postmanValue `json:"header"`
+	URL    postmanURL     `json:"url"`
+	Body   *postmanBody   `json:"body,omitempty"`
+}
+
+type postmanURL struct {
+	Raw      string         `json:"raw"`
+	Host     []string       `json:"host"`
+	Path     []string       `json:"path"`
+	Query    []postmanValue `json:"query,omitempty"`
+	Variable []postmanValue `json:"variable,omitempty"`
+}
+
+type postmanBody struct {
+	Mode    string `json:"mode"`
+	Raw     string `json:"raw"`
+	Options struct {
+		Raw struct {
+			Language string `json:"language"`
+		} `json:"raw"`
+	} `json:"options"`
+}
+
+type postmanValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func postmanCollection(name, baseURL string, reqs []exportRequest) ([]byte, error) {
+	collection := struct {
+		Info struct {
+			Name   string `json:"name"`
+			Schema string `json:"schema"`
+		} `json:"info"`
+		Item     []postmanItem  `json:"item"`
+		Variable []postmanValue `json:"variable"`
+	}{Item: []postmanItem{}}
+	collection.Info.Name, collection.Info.Schema = name, postmanSchema
+	collection.Variable = []postmanValue{{"baseUrl", baseURL}, {"token", ""}}
+
+	names, groups := exportGroups(reqs)
+	for _, group := range names {
+		var items []postmanItem
+		for _, req := range groups[group] {
+			items = append(items, postmanItem{Name: req.Name, Description: req.Description, Request: postmanRequestOf(req)})
+		}
+		if group == "" {
+			collection.Item = append(collection.Item, items...)
+			continue
+		}
+		collection.Item = append(collection.Item, postmanItem{Name: group, Item: items})
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(collection); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func postmanRequestOf(req exportRequest) *postmanRequest {
+	r := &postmanRequest{Method: req.Method, Header: []postmanValue{}}
+	r.URL.Host = []string{"{{baseUrl}}"}
+	r.URL.Path = strings.Split(strings.Trim(req.Path, "/"), "/")
+	for _, param := range req.Params {
+		r.URL.Variable = append(r.URL.Variable, postmanValue{param[0], param[1]})
+	}
+	for _, q := range req.Query {
+		r.URL.Query = append(r.URL.Query, postmanValue{q[0], q[1]})
+	}
+	r.URL.Raw = "{{baseUrl}}" + req.Path + queryString(req.Query)
+
+	if req.Auth {
+		r.Header = append(r.Header, postmanValue{"Authorization", "{{token}}"})
+	}
+	if req.Body != "" {
+		r.Header = append(r.Header, postmanValue{"Content-Type", "application/json"})
+		r.Body = &postmanBody{Mode: "raw", Raw: req.Body}
+		r.Body.Options.Raw.Language = "json"
+	}
+	return r
+}
+
+// queryString returns the query parameters as ?a=1&b=2, in order.
+func queryString(query [][2]string) string {
+	var sb strings.Builder
+	for i, q := range query {
+		if i == 0 {
+			sb.WriteByte('?')
+		} else {
+			sb.WriteByte('&')
+		}
+		sb.WriteString(url.QueryEscape(q[0]) + "=" + url.QueryEscape(q[1]))
+	}
+	return sb.String()
+}
+
+// httpFile renders the requests in the format of the REST Client extension.
+// Path parameters become file variables, like {{id}}.
+func httpFile(baseURL string, reqs []exportRequest) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "@baseUrl = %s\n@token =\n", baseURL)
+	seen := map[string]bool{}
+	for _, req := range reqs {
+		for _, param := range req.Params {
+			if !seen[param[0]] {
+				seen[param[0]] = true
+				fmt.Fprintf(&sb, "@%s = %s\n", param[0], param[1])
+			}
+		}
+	}
+
+	names, groups := exportGroups(reqs)
+	for _, group := range names {
+		if group != "" {
+			fmt.Fprintf(&sb, "\n# ---------- %s ----------\n", group)
+		}
+		for _, req := range groups[group] {
+			title := req.Name
+			if group != "" {
+				title = group + " / " + title
+			}
+			fmt.Fprintf(&sb, "\n### %s\n", title)
+			fmt.Fprintf(&sb, "# @name %s\n", req.Handler)
+			for _, line := range strings.Split(req.Description, "\n") {
+				if line != "" {
+					fmt.Fprintf(&sb, "# %s\n", line)
+				}
+			}
+			path := protoPathParam.ReplaceAllString(req.Path, "{{$2}}")
+			fmt.Fprintf(&sb, "%s {{baseUrl}}%s%s\n", req.Method, path, queryString(req.Query))
+			if req.Auth {
+				sb.WriteString("Authorization: {{token}}\n")
+			}
+			if req.Body != "" {
+				sb.WriteString("Content-Type: application/json\n\n" + req.Body + "\n")
+			}
+		}
+	}
+	return []byte(sb.String())
+}
@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	texttemplate "text/template"
 	"unicode"
 
 	"github.com/dave/dst"
@@ -22,42 +23,152 @@ import (
 )
 
 var logicTmp = `
+{{ if .Recv }}type {{ .RecvType }} struct{}
+
+func New{{ .RecvType }}() *{{ .RecvType }} {
+	return &{{ .RecvType }}{}
+}
+{{ end }}
 // this is logic
-func %sLogic(req %s) (resp %s, err error) {
+func {{ if .Recv }}(l {{ .Recv }}) {{ end }}{{ .HandlerName }}Logic({{ if .Context }}ctx context.Context{{ if .Req }}, {{ end }}{{ end }}{{ if .Req }}req {{ .Req }}{{ end }}) ({{ if .Resp }}resp {{ .Resp }}, {{ end }}err error) {
 	// TODO: add your logic here and delete this line
 
 	return
 }
 `
 
-func genLogicFunc(filename string, api TypeInfo) FuncInfo {
-	content := fmt.Sprintf(logicTmp, api.HandlerName, api.Req, api.Resp)
-	return WriteDecl(filename, content)
+type logicData struct {
+	TypeInfo
+	Recv     string
+	RecvType string
+	Context  bool
+}
+
+// genLogicFunc generates the logic function of the api. When handlers are
+// generated as methods, the logic function becomes a method of the logic
+// receiver so that handlers can hold it as a dependency.
+func genLogicFunc(tx *Tx, cfg Config, api TypeInfo) (FuncInfo, error) {
+	data := logicData{TypeInfo: api, Context: cfg.Logic.Context}
+	if cfg.Handler.Receiver != "" {
+		data.Recv = cfg.Logic.Receiver
+		data.RecvType = strings.TrimPrefix(cfg.Logic.Receiver, "*")
+	}
+
+	var imports []string
+	if cfg.Logic.Context {
+		imports = append(imports, "context")
+	}
+	imports = append(imports, api.ReqImports...)
+	imports = append(imports, api.RespImports...)
+
+	content, err := execTemplate(logicTmp, data)
+	if err != nil {
+		return FuncInfo{}, err
+	}
+	return writeDecl(tx, cfg.Logic.File, content, imports...)
+}
+
+// resolveLogicFunc returns the logic function of the api declared in the
+// logic file, for when the logic stage is disabled. Unnamed results are named
+// like the generated ones.
+func resolveLogicFunc(tx *Tx, cfg Config, api TypeInfo) (FuncInfo, error) {
+	recv := ""
+	if cfg.Handler.Receiver != "" {
+		recv = cfg.Logic.Receiver
+	}
+	info, err := resolveFunc(tx, cfg.Logic.File, api.HandlerName+"Logic", recv)
+	if err != nil {
+		return info, err
+	}
+	if len(info.Results) == 0 {
+		if api.Resp != "" {
+			info.Results = append(info.Results, "resp")
+		}
+		info.Results = append(info.Results, "err")
+	}
+	return info, nil
+}
+
+// resolveHandlerFunc returns the handler function of the api declared in
+// the handler file, for when the handler stage is disabled.
+func resolveHandlerFunc(tx *Tx, cfg Config, api TypeInfo) (FuncInfo, error) {
+	return resolveFunc(tx, cfg.Handler.File, api.HandlerName+"Handler", cfg.Handler.Receiver)
+}
+
+func resolveFunc(tx *Tx, filename, name, recv string) (FuncInfo, error) {
+	file, err := tx.ParseFile(filename)
+	if err != nil {
+		return FuncInfo{}, err
+	}
+	index, ok := isFunctionExists(file, name, recv)
+	if !ok {
+		return FuncInfo{}, errors.Errorf("function %s is not declared in %s, enable its stage to generate it", name, filename)
+	}
+	return parseFunc(file.Name.Name, file.Decls[index].(*dst.FuncDecl)), nil
 }
 
 var handlerTmp = `
-%s
-func %sHandler(c *gin.Context) {
-	var req %s
-	if err := c.ShouldBind(&req); err != nil {
-		util.FailWithMsg(c, util.WrapValidateErrMsg(err))
+{{ if .Recv }}type {{ .RecvType }} struct {
+	logic *{{ .Logic.Pkg }}.{{ .LogicType }}
+}
+
+func New{{ .RecvType }}(l *{{ .Logic.Pkg }}.{{ .LogicType }}) *{{ .RecvType }} {
+	return &{{ .RecvType }}{logic: l}
+}
+{{ end }}
+{{ .Annotation }}
+func {{ if .Recv }}(h {{ .Recv }}) {{ end }}{{ .HandlerName }}Handler(c *gin.Context) {
+{{- if .Req }}
+	{{ if .ReqElem }}req := new({{ .ReqElem }})
+	if err := c.ShouldBind(req); err != nil {{ else }}var req {{ .Req }}
+	if err := c.ShouldBind(&req); err != nil {{ end }}{
+		util.FailWithMsg(c, {{ .ErrMsg }})
 		return
 	}
-
-	%s := %s.%s(req)
+{{ end }}
+	{{ join .Logic.Results ", " }} := {{ if .Recv }}h.logic{{ else }}{{ .Logic.Pkg }}{{ end }}.{{ .Logic.FuncName }}({{ if .Context }}c.Request.Context(){{ if .Req }}, {{ end }}{{ end }}{{ if .Req }}req{{ end }})
 	if err != nil {
 		util.FailWithMsg(c, err.Error())
 		return
 	}
 
-	util.OKWithData(c, %s)
+	{{ if .Resp }}util.OKWithData(c, {{ index .Logic.Results 0 }}){{ else }}util.OK(c){{ end }}
 }
 `
 
+type handlerData struct {
+	TypeInfo
+	ReqElem    string // element type of a pointer request, bound through new
+	ErrMsg     string // expression translating the binding error
+	Annotation string
+	Recv       string
+	RecvType   string
+	LogicType  string
+	Logic      FuncInfo
+	Context    bool
+}
+
+// execTemplate renders the code template with the given data.
+func execTemplate(text string, data interface{}) (string, error) {
+	tmpl, err := texttemplate.New("code").Funcs(texttemplate.FuncMap{
+		"join":    strings.Join,
+		"comment": protoComment,
+	}).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
 const annotationTemplate = `{{ if .Summary }}// @Summary {{ .Summary }}{{ end }}{{ if and .Summary .Auth }}
-{{ end }}{{ if .Auth }}// @Security ApiKeyAuth{{ end }}
-// @Param {{ .HandlerName }} {{ .ParamType }} {{ .Req }} true "请求参数"
-// @Success 200	{object} util.Response{data={{ .Resp }}}
+{{ end }}{{ if .Auth }}// @Security ApiKeyAuth{{ end }}{{ if .Req }}
+// @Param {{ .HandlerName }} {{ .ParamType }} {{ .Req }} true "请求参数"{{ end }}
+// @Success 200	{object} util.Response{{ if .Resp }}{data={{ .Resp }}}{{ end }}
 // @Failure 400	{object} util.Response
 // @Router {{ .Group }}{{ .Path }} [{{ .Method|ToLower }}]`
 
@@ -75,12 +186,20 @@ type AnnotationData struct {
 
 // addSwagAnnotation generates a Swagger annotation for the given API info and config.
 // It uses a template to generate the annotation with the provided info.
-func addSwagAnnotation(info TypeInfo, cfg Config) string {
+func addSwagAnnotation(info TypeInfo, cfg Config) (string, error) {
 	tmpl, err := template.New("annotation").Funcs(template.FuncMap{
 		"ToLower": strings.ToLower,
 	}).Parse(annotationTemplate)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
+	}
+
+	group, err := getGroupPath(cfg.Router.File, cfg.Router.GroupFunc, info.Group)
+	if err != nil {
+		return "", err
+	}
+	if group = trimBasePath(joinPath(cfg.mountPath, group), cfg.Router.BasePath); group == "/" {
+		group = ""
 	}
 
 	var sb strings.Builder
@@ -88,18 +207,18 @@ func addSwagAnnotation(info TypeInfo, cfg Config) string {
 		Auth:        info.Auth,
 		HandlerName: info.HandlerName,
 		ParamType:   getParamType(info.Method),
-		Req:         info.Req,
-		Resp:        info.Resp,
-		Group:       getGroupPath(cfg.Router.File, cfg.Router.GroupFunc, info.Group),
+		Req:         strings.TrimPrefix(info.Req, "*"),
+		Resp:        strings.TrimPrefix(info.Resp, "*"),
+		Group:       group,
 		Path:        info.Path,
 		Method:      info.Method,
 		Summary:     info.Summary,
 	})
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 
-	return strings.TrimLeftFunc(sb.String(), unicode.IsSpace)
+	return strings.TrimLeftFunc(sb.String(), unicode.IsSpace), nil
 }
 
 func getParamType(method string) string {
@@ -109,63 +228,132 @@ func getParamType(method string) string {
 	return "body"
 }
 
-func genHandlerFunc(filename string, def TypeInfo, logic FuncInfo, cfg Config) FuncInfo {
-	// 要追加的内容
-	content := fmt.Sprintf(handlerTmp, addSwagAnnotation(def, cfg), def.HandlerName, def.Req, strings.Join(logic.Results, ", "), logic.Pkg, logic.FuncName, logic.Results[0])
+func genHandlerFunc(tx *Tx, def TypeInfo, logic FuncInfo, cfg Config) (FuncInfo, error) {
+	annotation, err := addSwagAnnotation(def, cfg)
+	if err != nil {
+		return FuncInfo{}, err
+	}
+	data := handlerData{
+		TypeInfo:   def,
+		Annotation: annotation,
+		Logic:      logic,
+		Context:    cfg.Logic.Context,
+		ErrMsg:     "util.WrapValidateErrMsg(err)",
+	}
+	if elem := strings.TrimPrefix(def.Req, "*"); elem != def.Req {
+		data.ReqElem = elem
+	}
+	if cfg.Handler.Receiver != "" {
+		data.Recv = cfg.Handler.Receiver
+		data.RecvType = strings.TrimPrefix(cfg.Handler.Receiver, "*")
+		data.LogicType = strings.TrimPrefix(cfg.Logic.Receiver, "*")
+	}
+
+	var imports []string
+	if logicPkg, err := pkgPath(cfg.Logic.File); err == nil {
+		imports = append(imports, logicPkg)
+	}
+	if cfg.Validate.File != "" {
+		pkg, err := dirPkgName(cfg.Validate.File)
+		if err != nil {
+			return FuncInfo{}, err
+		}
+		data.ErrMsg = pkg + ".ValidateErrMsg(c, err)"
+		if validatePkg, err := pkgPath(cfg.Validate.File); err == nil {
+			imports = append(imports, validatePkg)
+		}
+	}
+	// 处理函数只声明请求变量，响应直接透传
+	imports = append(imports, def.ReqImports...)
 
-	return WriteDecl(filename, content)
+	content, err := execTemplate(handlerTmp, data)
+	if err != nil {
+		return FuncInfo{}, err
+	}
+	return writeDecl(tx, cfg.Handler.File, content, imports...)
 }
 
 // WriteDecl writes a function declaration to the given Go source file.
 // It parses the existing file, appends the new function declaration,
-// and rewrites the file. It returns a FuncInfo struct containing
-// information about the new function.
-func WriteDecl(filename, decl string) (info FuncInfo) {
-	// 解析文件
-	fset := token.NewFileSet()
-	file, err := decorator.ParseFile(fset, filename, nil, parser.ParseComments)
+// and rewrites the file. Type declarations in decl are added when the
+// type does not exist yet, and the given import paths are added to the
+// file. It returns a FuncInfo struct containing information about the
+// last function in decl.
+func WriteDecl(filename, decl string, imports ...string) (info FuncInfo) {
+	tx := NewTx()
+	info, err := writeDecl(tx, filename, decl, imports...)
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	return info
+}
+
+// writeDecl stages the declarations of decl in the file of the transaction.
+func writeDecl(tx *Tx, filename, decl string, imports ...string) (info FuncInfo, err error) {
+	// 解析文件
+	file, err := tx.ParseFile(filename)
+	if err != nil {
+		return info, err
+	}
 
 	if !strings.Contains(decl, "package ") {
 		decl = "package main\n" + decl
 	}
 
 	// 将新函数的源代码解析为语法树
-	funcAST, err := decorator.ParseFile(fset, "", decl, parser.ParseComments)
+	funcAST, err := decorator.ParseFile(token.NewFileSet(), "", decl, parser.ParseComments)
 	if err != nil {
-		log.Fatal(err)
+		return info, errors.Wrap(err, "invalid generated code")
 	}
 
-	var funcs []*dst.FuncDecl
-	for _, v := range funcAST.Decls {
-		if f, ok := v.(*dst.FuncDecl); ok {
-			funcs = append(funcs, f)
+	added := false
+	for _, decl := range funcAST.Decls {
+		switch decl := decl.(type) {
+		case *dst.GenDecl:
+			if decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				name := spec.(*dst.TypeSpec).Name.Name
+				if isTypeExists(file, name) {
+					continue
+				}
+				file.Decls = append(file.Decls, &dst.GenDecl{Tok: token.TYPE, Specs: []dst.Spec{spec}, Decs: decl.Decs})
+				added = true
+				fmt.Println("New type", name, "will be added to", filename)
+			}
+		case *dst.FuncDecl:
+			info = parseFunc(file.Name.Name, decl)
+
+			index, _ := isFunctionExists(file, decl.Name.Name, info.Recv)
+			if index >= 0 {
+				// 如果函数名重复，可以选择跳过添加或者进行替换
+				fmt.Println("Function", decl.Name.Name, "already exists. Updating comments...")
+				// file.Decls[index].Decorations().Start = newFunc.Decs.Start
+			} else {
+				file.Decls = append(file.Decls, decl)
+				added = true
+				fmt.Print(color.GreenString("New function ["))
+				color.New(color.FgHiGreen, color.Bold).Print(decl.Name.Name)
+				color.Green("] will be added to %s.\n", filename)
+			}
 		}
 	}
-
-	for _, newFunc := range funcs {
-		info = parseFunc(file.Name.Name, newFunc)
-
-		index, _ := isFunctionExists(file, newFunc.Name.Name)
-		if index >= 0 {
-			// 如果函数名重复，可以选择跳过添加或者进行替换
-			fmt.Println("Function", newFunc.Name.Name, "already exists. Updating comments...")
-			// file.Decls[index].Decorations().Start = newFunc.Decs.Start
-		} else {
-			file.Decls = append(file.Decls, newFunc)
-			fmt.Print(color.GreenString("New function ["))
-			color.New(color.FgHiGreen, color.Bold).Print(newFunc.Name.Name)
-			color.Green("] will be added to %s.\n", filename)
+	// 已有的声明不变，导入的包可能用不到
+	if added {
+		for _, path := range imports {
+			addImport(file, path)
 		}
 	}
-	if err := reWrite(filename, file); err != nil {
-		log.Fatal(err)
+	if err := tx.Write(filename, file); err != nil {
+		return info, err
 	}
 
 	// fileAppend(filename, decl)
-	return
+	return info, nil
 }
 
 // formatAndWriteFile formats the given AST file using the given file set and
@@ -221,15 +409,29 @@ func fileAppend(filename, content string) error {
 }
 
 // 检查函数名是否存在
-func isFunctionExists(file *dst.File, functionName string) (index int, exist bool) {
+func isFunctionExists(file *dst.File, functionName, recv string) (index int, exist bool) {
 	for i, decl := range file.Decls {
-		if fn, ok := decl.(*dst.FuncDecl); ok && fn.Name.Name == functionName {
+		if fn, ok := decl.(*dst.FuncDecl); ok && fn.Name.Name == functionName && recvType(fn) == recv {
 			return i, true
 		}
 	}
 	return -1, false
 }
 
+// 检查类型是否存在
+func isTypeExists(file *dst.File, typeName string) bool {
+	for _, decl := range file.Decls {
+		if genDecl, ok := decl.(*dst.GenDecl); ok && genDecl.Tok == token.TYPE {
+			for _, spec := range genDecl.Specs {
+				if spec.(*dst.TypeSpec).Name.Name == typeName {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 type RouterExprInfo struct {
 	RG         string
 	Method     string
@@ -256,10 +458,12 @@ func hasEmptyLineAtEnd(filename string) (bool, error) {
 	return false, nil
 }
 
-// isRouterAdded checks if a router handler with the given RouterExprInfo is
-// added in the given list of statements. It recursively checks inside block
-// statements. Returns true if a matching router handler call expression is found.
-func isRouterAdded(stmts []dst.Stmt, info RouterExprInfo) bool {
+// findRouter finds the registration of the router handler with the given
+// RouterExprInfo in the given list of statements. It recursively checks
+// inside block statements. Returns nil if no matching call expression is
+// found. Middleware arguments between the path and the handler are not
+// compared.
+func findRouter(stmts []dst.Stmt, info RouterExprInfo) *dst.CallExpr {
 	for _, stmt := range stmts {
 		switch stmt := stmt.(type) {
 		case *dst.ExprStmt:
@@ -270,69 +474,75 @@ func isRouterAdded(stmts []dst.Stmt, info RouterExprInfo) bool {
 						funcName = indent.Name
 					}
 					method := selectorExpr.Sel.Name
-					if funcName != info.RG || method != info.Method || len(callExpr.Args) != 2 {
+					if funcName != info.RG || method != info.Method || len(callExpr.Args) < 2 {
 						continue
 					}
 				}
+				if len(callExpr.Args) < 2 {
+					continue
+				}
 				path := ""
 				handlerName := ""
 				handlerPkg := ""
 				if pathLit, ok := callExpr.Args[0].(*dst.BasicLit); ok {
 					path = pathLit.Value
 				}
-				if selExpr, ok := callExpr.Args[1].(*dst.SelectorExpr); ok {
-					handlerPkg = selExpr.X.(*dst.Ident).Name
+				if selExpr, ok := callExpr.Args[len(callExpr.Args)-1].(*dst.SelectorExpr); ok {
+					if ident, ok := selExpr.X.(*dst.Ident); ok {
+						handlerPkg = ident.Name
+					}
 					handlerName = selExpr.Sel.Name
 				}
 				if path == info.PathArg && handlerName == info.HandlerArg.HandlerFunc && handlerPkg == info.HandlerArg.HandlerPkg {
-					return true
+					return callExpr
 				}
 			}
 
 		case *dst.BlockStmt:
-			if isRouterAdded(stmt.List, info) {
-				return true
+			if call := findRouter(stmt.List, info); call != nil {
+				return call
 			}
 		}
 	}
-	return false
+	return nil
 }
 
-// findRouterGroup searches the given statements recursively to find a router
-// group registration with the given group name. It returns the variable name
-// that the router group is assigned to, or an empty string if no matching
-// router group is found.
-func findRouterGroup(stmts []dst.Stmt, group string) string {
-	for _, stmt := range stmts {
-		switch stmt := stmt.(type) {
-		case *dst.ExprStmt:
-			if call, ok := stmt.X.(*dst.CallExpr); ok {
-				if isRouterGroupCall(call, group) {
-					return getRouterGroupName(call)
-				}
-			}
-		case *dst.BlockStmt:
-			groupName := findRouterGroup(stmt.List, group)
-			if groupName != "" {
-				return groupName
-			}
-		case *dst.AssignStmt:
-			if len(stmt.Rhs) > 0 {
-				if call, ok := stmt.Rhs[0].(*dst.CallExpr); ok && isRouterGroupCall(call, group) {
-					if len(stmt.Lhs) > 0 {
-						if lhs, ok := stmt.Lhs[0].(*dst.Ident); ok {
-							return lhs.Name
-						}
-					}
-				}
-			}
+// routeMiddlewares resolves the middlewares of the api through the registry
+// in the config. The "auth" entry, if registered, is applied to every api
+// requiring authentication.
+func routeMiddlewares(cfg Config, api TypeInfo) ([]Middleware, error) {
+	names := api.Middlewares
+	if _, ok := cfg.Middleware["auth"]; ok && api.Auth {
+		names = append([]string{"auth"}, names...)
+	}
+
+	var middlewares []Middleware
+	for _, name := range names {
+		m, ok := cfg.Middleware[name]
+		if !ok {
+			return nil, errors.Errorf("middleware %q of api %s is not registered in config", name, api.Path)
 		}
+		middlewares = append(middlewares, m)
+	}
+	return middlewares, nil
+}
+
+// exprsString returns the source of the expressions separated by commas.
+func exprsString(exprs []dst.Expr) string {
+	list := make([]string, 0, len(exprs))
+	for _, expr := range exprs {
+		list = append(list, dstExprString(expr))
 	}
-	return ""
+	return strings.Join(list, ", ")
 }
 
-func isRouterGroupCall(call *dst.CallExpr, group string) bool {
-	return getGroupName(call) == group && isSelectorExpr(call.Fun, "Group")
+// parseExpr parses a Go expression into a dst node.
+func parseExpr(expr string) (dst.Expr, error) {
+	file, err := decorator.Parse("package main\nvar _ = " + expr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid expression %q", expr)
+	}
+	return file.Decls[0].(*dst.GenDecl).Specs[0].(*dst.ValueSpec).Values[0], nil
 }
 
 func getGroupName(call *dst.CallExpr) (group string) {
@@ -353,74 +563,27 @@ func isSelectorExpr(expr dst.Expr, name string) bool {
 	return false
 }
 
-func getRouterGroupName(call *dst.CallExpr) string {
-	selector := call.Fun.(*dst.SelectorExpr)
-	ident := selector.X.(*dst.Ident)
-	return ident.Name
-}
-
-// findAndInsert recursively searches through the statements to find the
-// router group matching the given group name. When found, it inserts the
-// new call expression into the block, handling proper indentation.
-func findAndInsert(stmts []dst.Stmt, newCallExpr dst.Stmt, group string) []dst.Stmt {
-	var found bool
-	for i, stmt := range stmts {
-		switch stmt := stmt.(type) {
-		case *dst.ExprStmt:
-			if call, ok := stmt.X.(*dst.CallExpr); ok {
-				if isRouterGroupCall(call, group) {
-					stmts = append(stmts[:i+1], insertBlock(stmts[i+1:], newCallExpr)...)
-					return stmts
-				}
-			}
-		case *dst.BlockStmt:
-			findAndInsert(stmt.List, newCallExpr, group)
-			if found {
-				stmt.List = append(stmt.List, newCallExpr)
-				return stmts
-			}
-		case *dst.AssignStmt:
-			if len(stmt.Rhs) > 0 {
-				if call, ok := stmt.Rhs[0].(*dst.CallExpr); ok && isRouterGroupCall(call, group) {
-					stmts = append(stmts[:i+1], insertBlock(stmts[i+1:], newCallExpr)...)
-					return stmts
-				}
-			}
-
-		}
-	}
-	if !found {
-		stmts = append(stmts, newCallExpr)
-	}
-	return stmts
-}
-
-func insertBlock(stmts []dst.Stmt, newCallExpr dst.Stmt) []dst.Stmt {
-	for _, stmt := range stmts {
-		switch stmt := stmt.(type) {
-		case *dst.BlockStmt:
-			stmt.List = append(stmt.List, newCallExpr)
-			return stmts
-		}
-	}
-	stmts = append(stmts, newCallExpr)
-	return stmts
-}
-
 // addRouter adds a new route handler to the router setup function in the
 // provided Go file. It searches for the target router setup function, finds
 // the correct location to insert the new route based on provided group name,
 // and inserts the handler expression without modifying existing routes.
-func addRouter(routerFile, routerFunc string, apiInfo TypeInfo, handlerFunc FuncInfo) (err error) {
+//
+// When routes is not nil, the new route is registered in it first and the
+// route is refused if it conflicts with the registered ones. The router file
+// is staged in tx.
+func addRouter(tx *Tx, cfg Config, apiInfo TypeInfo, logicFunc, handlerFunc FuncInfo, routes *RouteSet) (err error) {
+	routerFile := cfg.Router.File
 	// 查找目标函数
-	file, targetFunc, err := searchFunc(routerFile, routerFunc)
+	file, err := tx.ParseFile(routerFile)
+	if err != nil {
+		return err
+	}
+	targetFunc, err := findFunc(file, cfg.Router.GroupFunc)
 	if err != nil {
 		return err
 	}
 
-	x := findRootRG(targetFunc)
 	info := RouterExprInfo{
-		RG:      x,
 		Method:  apiInfo.Method,
 		PathArg: `"` + apiInfo.Path + `"`,
 		HandlerArg: struct {
@@ -428,13 +591,74 @@ func addRouter(routerFile, routerFunc string, apiInfo TypeInfo, handlerFunc Func
 			HandlerFunc string
 		}{handlerFunc.Pkg, handlerFunc.FuncName},
 	}
-	if apiInfo.Group != "" {
-		if g := findRouterGroup(targetFunc.Body.List, apiInfo.Group); g != "" {
-			info.RG = g
-		} else {
-			logrus.Warningf("Failed to find target group :%s", apiInfo.Group)
+	if handlerFunc.Recv != "" {
+		info.HandlerArg.HandlerPkg, err = wireHandler(file, targetFunc, cfg, logicFunc, handlerFunc)
+		if err != nil {
+			return err
+		}
+	}
+	tree := buildRouteTree(targetFunc)
+	node, groupPath, missing, err := resolveGroup(tree, apiInfo.Group)
+	if err != nil {
+		return err
+	}
+	info.RG = node.Caller
+	middlewares, err := routeMiddlewares(cfg, apiInfo)
+	if err != nil {
+		return err
+	}
+	args := []dst.Expr{dst.NewIdent(info.PathArg)}
+	for _, m := range middlewares {
+		expr, err := parseExpr(m.Expr)
+		if err != nil {
+			return err
+		}
+		args = append(args, expr)
+		if m.Import != "" {
+			addImport(file, m.Import)
+		}
+	}
+	// ast.NewIdent(handlerFunc.Pkg + "." + handlerFunc.FuncName),
+	args = append(args, &dst.SelectorExpr{X: dst.NewIdent(info.HandlerArg.HandlerPkg), Sel: dst.NewIdent(info.HandlerArg.HandlerFunc)})
+
+	if call := findRouter(targetFunc.Body.List, info); len(missing) == 0 && call != nil {
+		// 注解的 @auth 或 @middleware 改变时更新已有注册的中间件，保留路径和 handler
+		from, to := call.Args[1:len(call.Args)-1], args[1:len(args)-1]
+		if exprsString(from) == exprsString(to) {
+			log.Println("router", apiInfo.Path, "already exists. Skipping...")
+			return
+		}
+		logrus.Warningf("Middlewares of router %s changed from [%s] to [%s], updating its registration", apiInfo.Path, exprsString(from), exprsString(to))
+		call.Args = append(append([]dst.Expr{call.Args[0]}, to...), call.Args[len(call.Args)-1])
+		return tx.Write(routerFile, file)
+	}
+
+	if routes != nil {
+		route := Route{
+			Method:  apiInfo.Method,
+			Path:    joinPath(cfg.mountPath, joinPath(append(groupPath, missing...)...), apiInfo.Path),
+			Handler: info.HandlerArg.HandlerPkg + "." + info.HandlerArg.HandlerFunc,
 		}
+		for _, m := range middlewares {
+			route.Middlewares = append(route.Middlewares, m.Expr)
+		}
+		for _, r := range routes.routes {
+			if r.Handler == route.Handler {
+				logrus.Warningf("%s is already registered at %s %s (%s)", route.Handler, r.Method, r.Path, r.Pos)
+			}
+		}
+		if err := routes.Add(route); err != nil {
+			return err
+		}
+	}
+
+	// 创建缺失的路由组，如 api/apiv2 会逐级创建或复用
+	for _, seg := range missing {
+		node = node.addGroup(seg, groupVarName(targetFunc, seg))
+		logrus.Infof("New group %s(%s) will be added to %s", node.Caller, seg, routerFile)
 	}
+	info.RG = node.Caller
+
 	// 创建新的CallExpr节点
 	newCallExpr := &dst.ExprStmt{
 		X: &dst.CallExpr{
@@ -442,37 +666,13 @@ func addRouter(routerFile, routerFunc string, apiInfo TypeInfo, handlerFunc Func
 				X:   dst.NewIdent(info.RG),
 				Sel: dst.NewIdent(info.Method),
 			},
-			Args: []dst.Expr{
-				dst.NewIdent(info.PathArg),
-				// ast.NewIdent(handlerFunc.Pkg + "." + handlerFunc.FuncName),
-				&dst.SelectorExpr{X: dst.NewIdent(info.HandlerArg.HandlerPkg), Sel: dst.NewIdent(info.HandlerArg.HandlerFunc)},
-			},
+			Args: args,
 		},
 	}
 
-	if isRouterAdded(targetFunc.Body.List, info) {
-		log.Println("router", apiInfo.Path, "already exists. Skipping...")
-		return
-	}
-
-	// 创建新的文件节点，并按原始顺序将函数添加到该节点中
-	// newFile := &dst.File{
-	// 	Name:  file.Name,
-	// 	Decls: make([]dst.Decl, len(file.Decls)),
-	// }
-	// copy(newFile.Decls, file.Decls)
-
 	// 在目标函数体的语句列表中找到适当的位置插入新的调用表达式
-	if apiInfo.Group != "" && x != info.RG {
-		findAndInsert(targetFunc.Body.List, newCallExpr, apiInfo.Group)
-	} else {
-		// insertIndex := findInsertIndex(targetFunc.Body.List, targetFunc.Body.Lbrace+1, targetFunc.Body.Rbrace-1)
-		insertIndex := len(targetFunc.Body.List)
-		if targetFunc.Name.Name == "main" {
-			insertIndex--
-		}
-		targetFunc.Body.List = append(targetFunc.Body.List[:insertIndex], append([]dst.Stmt{newCallExpr}, targetFunc.Body.List[insertIndex:]...)...)
-	}
+	node.insert(newCallExpr)
+
 	// 将目标函数替换为修改后的函数
 	for i, decl := range file.Decls {
 		if fn, ok := decl.(*dst.FuncDecl); ok && fn.Name.Name == targetFunc.Name.Name {
@@ -481,8 +681,8 @@ func addRouter(routerFile, routerFunc string, apiInfo TypeInfo, handlerFunc Func
 		}
 	}
 
-	// 重新写入文件，保留原始文件的格式和注释
-	if err := reWrite(routerFile, file); err != nil {
+	// 暂存修改后的文件，保留原始文件的格式和注释
+	if err := tx.Write(routerFile, file); err != nil {
 		return err
 	}
 
@@ -490,24 +690,55 @@ func addRouter(routerFile, routerFunc string, apiInfo TypeInfo, handlerFunc Func
 	return nil
 }
 
-// reWrite overwrites the given file with the provided AST, preserving
-// the original formatting and comments.
-func reWrite(filename string, file *dst.File) error {
-	outputFile, err := os.OpenFile(filename, os.O_WRONLY|os.O_TRUNC, 0o644)
-	if err != nil {
-		fmt.Println("Failed to create file:", err)
-		return err
+// wireHandler makes sure the router function constructs the handler struct
+// and its logic dependency before registering routes, and returns the name
+// of the handler variable.
+func wireHandler(file *dst.File, routerFunc *dst.FuncDecl, cfg Config, logicFunc, handlerFunc FuncInfo) (string, error) {
+	handlerType := strings.TrimPrefix(handlerFunc.Recv, "*")
+	logicType := strings.TrimPrefix(logicFunc.Recv, "*")
+	varName := handlerVarName(handlerFunc.Recv)
+
+	for _, stmt := range routerFunc.Body.List {
+		if assign, ok := stmt.(*dst.AssignStmt); ok && len(assign.Lhs) > 0 {
+			if ident, ok := assign.Lhs[0].(*dst.Ident); ok && ident.Name == varName {
+				return varName, nil
+			}
+		}
 	}
-	defer outputFile.Close()
 
-	err = decorator.Fprint(outputFile, file)
-	if err != nil {
-		fmt.Println("Failed to write file:", err)
-		return err
+	for _, filename := range []string{cfg.Handler.File, cfg.Logic.File} {
+		path, err := pkgPath(filename)
+		if err != nil {
+			return "", err
+		}
+		addImport(file, path)
 	}
 
-	// fmt.Printf("File %s updated.\n", filename)
-	return nil
+	// userHandler := handler.NewUserHandler(logic.NewUserLogic())
+	stmt := &dst.AssignStmt{
+		Lhs: []dst.Expr{dst.NewIdent(varName)},
+		Tok: token.DEFINE,
+		Rhs: []dst.Expr{&dst.CallExpr{
+			Fun: &dst.SelectorExpr{X: dst.NewIdent(handlerFunc.Pkg), Sel: dst.NewIdent("New" + handlerType)},
+			Args: []dst.Expr{&dst.CallExpr{
+				Fun: &dst.SelectorExpr{X: dst.NewIdent(logicFunc.Pkg), Sel: dst.NewIdent("New" + logicType)},
+			}},
+		}},
+	}
+	stmt.Decs.After = dst.EmptyLine
+	routerFunc.Body.List = append([]dst.Stmt{stmt}, routerFunc.Body.List...)
+
+	return varName, nil
+}
+
+// handlerVarName returns the name of the handler variable in the router
+// function for the handler receiver, like userHandler for *UserHandler.
+func handlerVarName(recv string) string {
+	handlerType := strings.TrimPrefix(recv, "*")
+	if handlerType == "" {
+		return ""
+	}
+	return string(unicode.ToLower(rune(handlerType[0]))) + handlerType[1:]
 }
 
 // searchFunc searches the given routerFile for a function declaration
@@ -521,16 +752,20 @@ func searchFunc(routerFile string, routerFunc string) (*dst.File, *dst.FuncDecl,
 		return nil, nil, err
 	}
 
-	var targetFunc *dst.FuncDecl
-	for _, decl := range file.Decls {
-		if fn, ok := decl.(*dst.FuncDecl); ok && fn.Name.Name == routerFunc {
-			targetFunc = fn
-			break
-		}
-	}
-	if targetFunc == nil {
-		return nil, nil, fmt.Errorf("Failed to find target func :%s ,%v", routerFunc, err)
+	targetFunc, err := findFunc(file, routerFunc)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	return file, targetFunc, nil
 }
+
+// findFunc returns the function declaration with the given name.
+func findFunc(file *dst.File, name string) (*dst.FuncDecl, error) {
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*dst.FuncDecl); ok && fn.Name.Name == name {
+			return fn, nil
+		}
+	}
+	return nil, fmt.Errorf("Failed to find target func :%s", name)
+}
@@ -0,0 +1,371 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var grpcServerTmp = `
+type {{ .Service }} struct {
+	{{ .PbPkg }}.Unimplemented{{ .Service }}Server
+{{- if .LogicType }}
+	logic *{{ .LogicPkg }}.{{ .LogicType }}
+{{- end }}
+}
+
+func New{{ .Service }}({{ if .LogicType }}l *{{ .LogicPkg }}.{{ .LogicType }}{{ end }}) *{{ .Service }} {
+	return &{{ .Service }}{ {{- if .LogicType }}logic: l{{ end -}} }
+}
+
+// {{ .RPC }} calls {{ .LogicPkg }}.{{ .Logic }} for the gRPC transport.
+func (s *{{ .Service }}) {{ .RPC }}(ctx context.Context, in *{{ .In }}) (*{{ .Out }}, error) {
+{{- if .Req }}
+	{{ .Req }}
+{{- end }}
+	{{ if .Resp }}resp, {{ end }}err := {{ if .LogicType }}s.logic{{ else }}{{ .LogicPkg }}{{ end }}.{{ .Logic }}({{ .Args }})
+	if err != nil {
+		return nil, err
+	}
+{{- if .Resp }}
+
+	out := new({{ .Out }})
+	{{ .Resp }}
+	return out, nil
+{{- else }}
+	return new({{ .Out }}), nil
+{{- end }}
+}
+`
+
+type grpcServerData struct {
+	Service   string
+	PbPkg     string
+	LogicPkg  string
+	LogicType string
+	Logic     string
+	RPC       string
+	In, Out   string
+	Req, Resp string // statements converting the request and response
+	Args      string
+}
+
+var grpcConvertTmp = `// Code generated by api-gen. DO NOT EDIT.
+
+package {{ .Pkg }}
+
+import (
+{{- range .Imports }}
+	{{ printf "%q" . }}
+{{- end }}
+)
+{{ range .Funcs }}
+{{ . }}
+{{ end }}
+type convNumber interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~float32 | ~float64
+}
+
+func convertNum[T, F convNumber](dst *T, v F) { *dst = T(v) }
+
+func convertStr[T, F ~string](dst *T, v F) { *dst = T(v) }
+
+func convertBool[T, F ~bool](dst *T, v F) { *dst = T(v) }
+
+// alloc points p to a new value and returns it.
+func alloc[T any](p **T) *T {
+	*p = new(T)
+	return *p
+}
+
+// grow makes *p a slice of length n and returns it.
+func grow[T any](p *[]T, n int) []T {
+	*p = make([]T, n)
+	return *p
+}
+
+// makeMap makes *p a map with room for n entries and returns it.
+func makeMap[K comparable, V any](p *map[K]V, n int) map[K]V {
+	*p = make(map[K]V, n)
+	return *p
+}
+
+// zero returns zero values of the key and value types of m.
+func zero[K comparable, V any](map[K]V) (k K, v V) { return }
+`
+
+// grpcGen generates the gRPC server adapter and the conversion functions.
+type grpcGen struct {
+	*protoGen
+	pbPath, pbPkg string
+	imports       map[string]bool // imports of the conversion file
+}
+
+func newGRPCGen(cfg Config, m *Model) (*grpcGen, error) {
+	if cfg.Proto.GoPackage == "" {
+		return nil, errors.New("grpc requires proto.goPackage to import the generated messages")
+	}
+	pbPath, pbPkg, ok := strings.Cut(cfg.Proto.GoPackage, ";")
+	if !ok {
+		pbPkg = path.Base(pbPath)
+	}
+	g := &grpcGen{
+		protoGen: newProtoGen(cfg, m, &protoLock{Messages: map[string]map[string]int{}}),
+		pbPath:   pbPath,
+		pbPkg:    pbPkg,
+		imports:  map[string]bool{pbPath: true},
+	}
+	for _, api := range m.APIs {
+		g.body(api.RequestType, api.Request, protoName(api.Handler)+"Request")
+		g.body(api.ResponseType, api.Response, protoName(api.Handler)+"Response")
+	}
+	return g, nil
+}
+
+// genGRPC adds the method of the api to the gRPC server adapter, and
+// regenerates the conversion functions of the messages.
+func genGRPC(tx *Tx, cfg Config, m *Model, api API) error {
+	g, err := newGRPCGen(cfg, m)
+	if err != nil {
+		return err
+	}
+	file := cfg.GRPC.File
+	pkg, err := dirPkgName(file)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ReadFile(file); os.IsNotExist(err) {
+		tx.WriteFile(file, []byte("package "+pkg+"\n"))
+	}
+
+	logicPkg, err := dirPkgName(cfg.Logic.File)
+	if err != nil {
+		return err
+	}
+	service := protoName(api.Group)
+	if service == "" {
+		service = protoName(m.Package)
+	}
+	data := grpcServerData{
+		Service:  service + "Service",
+		PbPkg:    g.pbPkg,
+		LogicPkg: logicPkg,
+		Logic:    api.Handler + "Logic",
+		RPC:      protoName(api.Handler),
+	}
+	if cfg.Handler.Receiver != "" {
+		data.LogicType = strings.TrimPrefix(cfg.Logic.Receiver, "*")
+	}
+	imports := []string{"context", g.pbPath}
+	if logicPath, err := pkgPath(cfg.Logic.File); err == nil {
+		imports = append(imports, logicPath)
+	}
+
+	var args []string
+	if cfg.Logic.Context {
+		args = append(args, "ctx")
+	}
+	in := g.body(api.RequestType, api.Request, data.RPC+"Request")
+	data.In = g.goMessage(in)
+	if api.RequestType != "" {
+		args = append(args, "req")
+		imports = append(imports, api.RequestImports...)
+		data.Req = g.fromProto(api.RequestType, in)
+	}
+	data.Args = strings.Join(args, ", ")
+
+	out := g.body(api.ResponseType, api.Response, data.RPC+"Response")
+	data.Out = g.goMessage(out)
+	if api.ResponseType != "" {
+		data.Resp = g.toProto(api.ResponseType, out)
+	}
+	if api.RequestType == "" || api.ResponseType == "" {
+		imports = append(imports, "google.golang.org/protobuf/types/known/emptypb")
+	}
+
+	content, err := execTemplate(grpcServerTmp, data)
+	if err != nil {
+		return err
+	}
+	if _, err := writeDecl(tx, file, content, imports...); err != nil {
+		return err
+	}
+	return g.writeConvert(tx, filepath.Join(filepath.Dir(file), "convert.go"), pkg)
+}
+
+// goMessage returns the Go type of a proto message.
+func (g *grpcGen) goMessage(name string) string {
+	switch name {
+	case "google.protobuf.Empty":
+		return "emptypb.Empty"
+	}
+	return g.pbPkg + "." + goCamelCase(name)
+}
+
+// fromProto returns the statements converting in into req of the Go type.
+func (g *grpcGen) fromProto(typ, msg string) string {
+	m := g.messages[msg]
+	if m.schema == nil {
+		return fmt.Sprintf("var req %s\n%s", typ, g.convert(m.Fields[0].conv, "req", "in."+goCamelCase(m.Fields[0].Name), false, 0))
+	}
+	if elem, ok := strings.CutPrefix(typ, "*"); ok {
+		return fmt.Sprintf("req := new(%s)\n%sFromProto(req, in)", elem, msg)
+	}
+	return fmt.Sprintf("var req %s\n%sFromProto(&req, in)", typ, msg)
+}
+
+// toProto returns the statements converting resp of the Go type into out.
+func (g *grpcGen) toProto(typ, msg string) string {
+	m := g.messages[msg]
+	if m.schema == nil {
+		return g.convert(m.Fields[0].conv, "out."+goCamelCase(m.Fields[0].Name), "resp", true, 0)
+	}
+	if strings.HasPrefix(typ, "*") {
+		return fmt.Sprintf("if resp != nil {\n%sToProto(out, *resp)\n}", msg)
+	}
+	return fmt.Sprintf("%sToProto(out, resp)", msg)
+}
+
+// writeConvert renders the conversion functions of the messages with a Go
+// struct into the file.
+func (g *grpcGen) writeConvert(tx *Tx, filename, pkg string) error {
+	var funcs []string
+	for _, m := range g.order {
+		if m.schema == nil || m.schema.Name == "" {
+			continue
+		}
+		goType := m.schema.TypeName()
+		if m.schema.PkgPath != "" {
+			g.imports[m.schema.PkgPath] = true
+		} else if p, err := pkgPath(g.cfg.TypeFile); err == nil {
+			g.imports[p] = true
+		}
+		pbType := g.goMessage(m.Name)
+
+		var from, to strings.Builder
+		for _, f := range m.Fields {
+			goField, pbField := "."+f.goName, "."+goCamelCase(f.Name)
+			from.WriteString(g.convert(f.conv, "out"+goField, "in"+pbField, false, 0) + "\n")
+			to.WriteString(g.convert(f.conv, "out"+pbField, "in"+goField, true, 0) + "\n")
+		}
+		funcs = append(funcs,
+			fmt.Sprintf("// %[1]sFromProto copies the proto message into the Go struct.\nfunc %[1]sFromProto(out *%[2]s, in *%[3]s) {\nif in == nil {\nreturn\n}\n%[4]s}", m.Name, goType, pbType, from.String()),
+			fmt.Sprintf("// %[1]sToProto copies the Go struct into the proto message.\nfunc %[1]sToProto(out *%[3]s, in %[2]s) {\n%[4]s}", m.Name, goType, pbType, to.String()),
+		)
+	}
+
+	if len(funcs) == 0 {
+		delete(g.imports, g.pbPath)
+	}
+	imports := make([]string, 0, len(g.imports))
+	for p := range g.imports {
+		imports = append(imports, p)
+	}
+	sort.Strings(imports)
+	content, err := execTemplate(grpcConvertTmp, map[string]interface{}{
+		"Pkg":     pkg,
+		"Imports": imports,
+		"Funcs":   funcs,
+	})
+	if err != nil {
+		return err
+	}
+	src, err := format.Source([]byte(content))
+	if err != nil {
+		return errors.Wrap(err, "failed to format the conversion functions")
+	}
+	if old, err := tx.ReadFile(filename); err == nil && bytes.Equal(old, src) {
+		return nil
+	}
+	tx.WriteFile(filename, src)
+	fmt.Println("File", filename, "will be written.")
+	return nil
+}
+
+// convert returns the statements converting src into the addressable dst,
+// from Go to proto when toProto is set and back otherwise. depth keeps the
+// names of nested loop variables apart.
+func (g *grpcGen) convert(c *convType, dst, src string, toProto bool, depth int) string {
+	switch c.kind {
+	case convNum, convStr, convBool:
+		fn := map[string]string{convNum: "convertNum", convStr: "convertStr", convBool: "convertBool"}[c.kind]
+		return fmt.Sprintf("%s(%s, %s)", fn, addr(dst), src)
+	case convBytes:
+		return fmt.Sprintf("%s = %s", dst, src)
+	case convTime, convDuration:
+		pkg, fn, as := "timestamppb", "New", "AsTime"
+		if c.kind == convDuration {
+			pkg, as = "durationpb", "AsDuration"
+		}
+		if toProto {
+			g.imports["google.golang.org/protobuf/types/known/"+pkg] = true
+			return fmt.Sprintf("%s = %s.%s(%s)", dst, pkg, fn, src)
+		}
+		return fmt.Sprintf("if %s != nil {\n%s = %s.%s()\n}", src, dst, src, as)
+	case convMsg:
+		if toProto {
+			return fmt.Sprintf("%sToProto(alloc(%s), %s)", c.msg, addr(dst), src)
+		}
+		return fmt.Sprintf("%sFromProto(%s, %s)", c.msg, addr(dst), src)
+	case convPtr:
+		// 标量的指针对应 optional 字段，其余类型在 proto 中本就是指针
+		scalar := c.elem.kind == convNum || c.elem.kind == convStr || c.elem.kind == convBool
+		elemDst, elemSrc := "*alloc("+addr(dst)+")", "*"+src
+		if toProto && !scalar {
+			elemDst = dst
+		}
+		if !toProto && !scalar {
+			elemSrc = src
+		}
+		return fmt.Sprintf("if %s != nil {\n%s\n}", src, g.convert(c.elem, elemDst, elemSrc, toProto, depth))
+	case convSlice:
+		s, i, v := fmt.Sprint("s", depth), fmt.Sprint("i", depth), fmt.Sprint("v", depth)
+		return fmt.Sprintf("if len(%[1]s) > 0 {\n%[2]s := grow(%[3]s, len(%[1]s))\nfor %[4]s, %[5]s := range %[1]s {\n%[6]s\n}\n}",
+			src, s, addr(dst), i, v, g.convert(c.elem, s+"["+i+"]", v, toProto, depth+1))
+	case convMap:
+		m, k, v := fmt.Sprint("m", depth), fmt.Sprint("k", depth), fmt.Sprint("v", depth)
+		return fmt.Sprintf("if len(%[1]s) > 0 {\n%[2]s := makeMap(%[3]s, len(%[1]s))\nfor %[4]s, %[5]s := range %[1]s {\nkey, value := zero(%[2]s)\n%[6]s\n%[7]s\n%[2]s[key] = value\n}\n}",
+			src, m, addr(dst), k, v, g.convert(c.key, "key", k, toProto, depth+1), g.convert(c.elem, "value", v, toProto, depth+1))
+	}
+	return fmt.Sprintf("// TODO: convert %s", src)
+}
+
+// addr returns the address of the addressable expression.
+func addr(expr string) string {
+	if p, ok := strings.CutPrefix(expr, "*"); ok {
+		return p
+	}
+	return "&" + expr
+}
+
+// goCamelCase returns the Go name protoc-gen-go gives to a proto name, like
+// UserId for user_id.
+func goCamelCase(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '_' && i == 0:
+			b = append(b, 'X')
+		case c == '_' && i+1 < len(s) && 'a' <= s[i+1] && s[i+1] <= 'z':
+		case '0' <= c && c <= '9':
+			b = append(b, c)
+		default:
+			if 'a' <= c && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			b = append(b, c)
+			for ; i+1 < len(s) && 'a' <= s[i+1] && s[i+1] <= 'z'; i++ {
+				b = append(b, s[i+1])
+			}
+		}
+	}
+	return string(b)
+}
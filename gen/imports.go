@@ -0,0 +1,90 @@
+package gen
+
+import (
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/dave/dst"
+	"github.com/pkg/errors"
+	"golang.org/x/mod/modfile"
+)
+
+// pkgPath returns the import path of the package that contains filename,
+// derived from the nearest go.mod found in the file's directory or its parents.
+func pkgPath(filename string) (string, error) {
+	abs, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return "", err
+	}
+	root, err := moduleRoot(abs)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(modfile.ModulePath(data), filepath.ToSlash(rel)), nil
+}
+
+// moduleRoot returns the directory of the nearest go.mod found in dir or its parents.
+func moduleRoot(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		}
+		if filepath.Dir(dir) == dir {
+			return "", errors.Errorf("no go.mod found for %s", abs)
+		}
+	}
+}
+
+// addImport adds the import path to the file if it is not imported yet.
+func addImport(file *dst.File, importPath string) {
+	value := strconv.Quote(importPath)
+
+	var importDecl *dst.GenDecl
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*dst.GenDecl)
+		if !ok || genDecl.Tok != token.IMPORT {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			if spec.(*dst.ImportSpec).Path.Value == value {
+				return
+			}
+		}
+		if importDecl == nil {
+			importDecl = genDecl
+		}
+	}
+
+	spec := &dst.ImportSpec{Path: &dst.BasicLit{Kind: token.STRING, Value: value}}
+	if importDecl == nil {
+		importDecl = &dst.GenDecl{Tok: token.IMPORT}
+		file.Decls = append([]dst.Decl{importDecl}, file.Decls...)
+	}
+	importDecl.Specs = append(importDecl.Specs, spec)
+	if len(importDecl.Specs) > 1 {
+		importDecl.Lparen = true
+		importDecl.Rparen = true
+		for _, spec := range importDecl.Specs {
+			spec.Decorations().Before = dst.NewLine
+			spec.Decorations().After = dst.NewLine
+		}
+	}
+	file.Imports = append(file.Imports, spec)
+}
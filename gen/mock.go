@@ -0,0 +1,243 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var mockTmp = `// Code generated by api-gen mock. DO NOT EDIT.
+
+//go:build mock
+
+// Command mock serves the annotated apis with example responses. Requests
+// are bound and validated like the generated handlers do. Run it with
+// go run -tags mock.
+package main
+
+import (
+{{- range .Imports }}
+	{{ printf "%q" . }}
+{{- end }}
+{{ range .Deps }}
+	{{ printf "%q" . }}
+{{- end }}
+)
+
+func main() {
+	addr := flag.String("addr", {{ printf "%q" .Addr }}, "address to listen on")
+	flag.Parse()
+
+	r := gin.Default()
+{{- range .Routes }}
+
+	{{ comment .Comment "\t" }}	r.{{ .Method }}({{ printf "%q" .Path }}, func(c *gin.Context) {
+	{{- if .Req }}
+		{{ if .ReqElem }}req := new({{ .ReqElem }})
+		if err := c.ShouldBind(req); err != nil {{ else }}var req {{ .Req }}
+		if err := c.ShouldBind(&req); err != nil {{ end }}{
+			util.FailWithMsg(c, {{ $.ErrMsg }})
+			return
+		}
+	{{- end }}
+		{{ if .Example }}util.OKWithData(c, json.RawMessage({{ .Example }})){{ else }}util.OK(c){{ end }}
+	})
+{{- end }}
+
+	if err := r.Run(*addr); err != nil {
+		log.Fatal(err)
+	}
+}
+`
+
+// MockOptions configures the mock server.
+type MockOptions struct {
+	Addr string // default listen address of the server
+	Seed int64  // seed of the faked examples
+}
+
+type mockRoute struct {
+	Method  string
+	Path    string
+	Comment string
+	Req     string
+	ReqElem string // element type of a pointer request, bound through new
+	Example string // literal of the JSON of the response, empty for apis without response
+}
+
+// GenMock writes the source of a gin server registering every annotated api
+// of the config at its full path into dir/main.go, and returns the file.
+// Requests are bound into the request types, so the server must be built
+// within the module. The file is constrained to the mock build tag, so it
+// stays out of the builds and the route analysis of the module. Responses
+// are examples of the response types, taken from their example tags or
+// faked, in the util.Response envelope.
+func GenMock(cfg Config, dir string, opts MockOptions) (string, error) {
+	m, err := ParseModel(cfg.TypeFile)
+	if err != nil {
+		return "", err
+	}
+	utilPath, err := utilImport(cfg.Handler.File)
+	if err != nil {
+		return "", err
+	}
+
+	mount, err := routerMount(cfg)
+	if err != nil {
+		logrus.Warningf("Failed to analyze the routes of the module: %v", err)
+		mount = "/"
+	}
+	data := struct {
+		Imports []string // standard library
+		Deps    []string
+		Addr    string
+		ErrMsg  string
+		Routes  []mockRoute
+	}{Addr: opts.Addr, ErrMsg: "util.WrapValidateErrMsg(err)"}
+	if data.Addr == "" {
+		data.Addr = ":8080"
+	}
+
+	imports := map[string]bool{"flag": true, "log": true, "github.com/gin-gonic/gin": true, utilPath: true}
+	if cfg.Validate.File != "" {
+		pkg, err := dirPkgName(cfg.Validate.File)
+		if err != nil {
+			return "", err
+		}
+		validatePkg, err := pkgPath(cfg.Validate.File)
+		if err != nil {
+			return "", err
+		}
+		data.ErrMsg = pkg + ".ValidateErrMsg(c, err)"
+		imports[validatePkg] = true
+	}
+
+	examples := exampleGen{seed: opts.Seed, key: "json"}
+	for _, api := range m.APIs {
+		route := mockRoute{
+			Method:  api.Method,
+			Path:    apiPath(cfg, mount, api),
+			Comment: strings.TrimSpace(api.Summary + "\n" + api.Comment),
+			Req:     api.RequestType,
+		}
+		if route.Method == "ANY" {
+			route.Method = "Any"
+		}
+		if elem := strings.TrimPrefix(api.RequestType, "*"); elem != api.RequestType {
+			route.ReqElem = elem
+		}
+		for _, path := range api.RequestImports {
+			imports[path] = true
+		}
+		if example := examples.body(api.ResponseType, api.Response); api.ResponseType != "" {
+			b, err := json.Marshal(example)
+			if err != nil {
+				return "", errors.Wrapf(err, "api %s: failed to marshal the example response", api.Path)
+			}
+			route.Example = strconv.Quote(string(b))
+			if !bytes.ContainsRune(b, '`') {
+				route.Example = "`" + string(b) + "`"
+			}
+			imports["encoding/json"] = true
+		}
+		data.Routes = append(data.Routes, route)
+	}
+	for path := range imports {
+		// 标准库与第三方包分组导入
+		if first, _, _ := strings.Cut(path, "/"); strings.Contains(first, ".") {
+			data.Deps = append(data.Deps, path)
+		} else {
+			data.Imports = append(data.Imports, path)
+		}
+	}
+	sort.Strings(data.Imports)
+	sort.Strings(data.Deps)
+
+	content, err := execTemplate(mockTmp, data)
+	if err != nil {
+		return "", err
+	}
+	src, err := format.Source([]byte(content))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to format the mock server")
+	}
+
+	filename := filepath.Join(dir, "main.go")
+	tx := NewTx()
+	if old, err := tx.ReadFile(filename); err == nil && bytes.Equal(old, src) {
+		return filename, nil
+	}
+	tx.WriteFile(filename, src)
+	fmt.Println("File", filename, "will be written.")
+	return filename, tx.Commit()
+}
+
+// ServeMock generates the mock server into a temporary directory of the
+// module and runs it with go run until it exits or is interrupted.
+func ServeMock(cfg Config, opts MockOptions) error {
+	root, err := moduleRoot(filepath.Dir(cfg.TypeFile))
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp(root, "apigen-mock-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := GenMock(cfg, dir, opts); err != nil {
+		return err
+	}
+	args := []string{"run", "-tags", "mock", "./" + filepath.Base(dir)}
+	if opts.Addr != "" {
+		args = append(args, "-addr", opts.Addr)
+	}
+	cmd := exec.Command("go", args...)
+	cmd.Dir, cmd.Stdin, cmd.Stdout, cmd.Stderr = root, os.Stdin, os.Stdout, os.Stderr
+
+	// 中断信号同样发给了 go run，等待其退出后再清理临时目录
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && !exitErr.Exited() {
+			return nil
+		}
+		return errors.Wrap(err, "mock server")
+	}
+	return nil
+}
+
+// utilImport returns the import path of the util package the handler file
+// responds with.
+func utilImport(handlerFile string) (string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), handlerFile, nil, parser.ImportsOnly)
+	if err != nil {
+		return "", err
+	}
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := path[strings.LastIndex(path, "/")+1:]
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if name == "util" {
+			return path, nil
+		}
+	}
+	return "", errors.Errorf("no util package is imported by %s", handlerFile)
+}
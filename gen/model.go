@@ -0,0 +1,504 @@
+package gen
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Model is the parsed definition of the apis of a type file. It is the
+// single source every generator consumes.
+type Model struct {
+	File    string `json:"file"`
+	Package string `json:"package"`
+	APIs    []API  `json:"apis"`
+}
+
+// API is an api annotated in the type file.
+type API struct {
+	Path        string   `json:"path"`
+	Method      string   `json:"method"`
+	Handler     string   `json:"handler"` // name of the handler, like Login
+	Group       string   `json:"group,omitempty"`
+	Summary     string   `json:"summary,omitempty"`
+	Auth        bool     `json:"auth"`
+	Middlewares []string `json:"middlewares,omitempty"`
+
+	// RequestType and ResponseType are the Go types of the bodies qualified
+	// with their package names, like types.LoginReq or []types.Item. They
+	// are empty for apis without request or response.
+	RequestType     string   `json:"requestType,omitempty"`
+	ResponseType    string   `json:"responseType,omitempty"`
+	RequestImports  []string `json:"requestImports,omitempty"` // import paths of the packages used by RequestType
+	ResponseImports []string `json:"responseImports,omitempty"`
+
+	// Request and Response are the structs the types refer to, through
+	// pointers, slices and maps.
+	Request  *Schema `json:"request,omitempty"`
+	Response *Schema `json:"response,omitempty"`
+
+	Comment string `json:"comment,omitempty"` // doc comment without the annotations
+	Pos     string `json:"pos"`
+}
+
+// Schema is a struct type.
+type Schema struct {
+	Name    string  `json:"name"` // empty for inline structs
+	Pkg     string  `json:"pkg,omitempty"`
+	PkgPath string  `json:"pkgPath,omitempty"`
+	Comment string  `json:"comment,omitempty"`
+	Fields  []Field `json:"fields"`
+}
+
+// Field is an exported field of a struct. Fields of struct types,
+// inline, embedded or declared in other files and packages, are resolved
+// into Schema, except for recursive references.
+type Field struct {
+	Name     string            `json:"name"` // type name for embedded fields
+	Type     string            `json:"type"` // Go type, like []*Item
+	Tag      string            `json:"tag,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Required bool              `json:"required"`
+	Comment  string            `json:"comment,omitempty"`
+	Embedded bool              `json:"embedded,omitempty"`
+	Enum     []string          `json:"enum,omitempty"`  // values of the constants of a named basic type
+	Basic    string            `json:"basic,omitempty"` // underlying type of a named basic type, like int for Status
+	Schema   *Schema           `json:"schema,omitempty"`
+}
+
+// TypeName returns the qualified name of the schema, like types.LoginReq.
+func (s *Schema) TypeName() string {
+	if s == nil || s.Name == "" {
+		return ""
+	}
+	if s.Pkg == "" {
+		return s.Name
+	}
+	return s.Pkg + "." + s.Name
+}
+
+// TypeInfo returns the api in the form used by the code templates.
+func (a API) TypeInfo(pkg string) TypeInfo {
+	return TypeInfo{
+		Req:         a.RequestType,
+		Resp:        a.ResponseType,
+		PkgName:     pkg,
+		ReqImports:  a.RequestImports,
+		RespImports: a.ResponseImports,
+		ApiInfo: ApiInfo{
+			Path:        a.Path,
+			Method:      a.Method,
+			HandlerName: a.Handler,
+			Auth:        a.Auth,
+			Group:       a.Group,
+			Summary:     a.Summary,
+			Middlewares: a.Middlewares,
+		},
+	}
+}
+
+// API returns the api with the given path.
+func (m *Model) API(path string) (API, bool) {
+	for _, api := range m.APIs {
+		if api.Path == path {
+			return api, true
+		}
+	}
+	return API{}, false
+}
+
+// schemaResolver resolves type expressions written in the type file.
+type schemaResolver interface {
+	// resolve returns the type qualified with package names, the import
+	// paths it uses and the schema of the struct it refers to.
+	resolve(expr string) (typ string, imports []string, schema *Schema, err error)
+}
+
+// ParseModel parses the annotated apis of the type file, in the order they
+// are declared. Types are resolved by type-checking the package of the type
+// file with go/packages; if it can't be loaded, only the structs of the type
+// file are resolved.
+func ParseModel(filename string) (*Model, error) {
+	return ParseModelSource(filename, nil)
+}
+
+// ParseModelSource is like ParseModel, with src as the content of the type
+// file when it is not nil, like a previous revision of the file. The other
+// files of the module are read from disk.
+func ParseModelSource(filename string, src []byte) (*Model, error) {
+	// nil 的 []byte 也会被当作文件内容
+	var source interface{}
+	if src != nil {
+		source = src
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, source, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolver schemaResolver
+	if r, err := loadTypes(filename, src); err == nil {
+		resolver = r
+	} else {
+		logrus.Warningf("Failed to load the package of %s, resolving types within the file: %v", filename, err)
+		path, _ := pkgPath(filename)
+		resolver = newModelParser(file, path)
+	}
+
+	m := &Model{File: filename, Package: file.Name.Name, APIs: []API{}}
+	for _, d := range apiDecls(file) {
+		info := d.info
+		pos := ast.Node(d.decl)
+		if d.spec != nil {
+			pos = d.spec
+		}
+		api := API{
+			Path:        info.Path,
+			Method:      info.Method,
+			Handler:     info.HandlerName,
+			Group:       info.Group,
+			Summary:     info.Summary,
+			Auth:        info.Auth,
+			Middlewares: info.Middlewares,
+			Comment:     docText(d.doc),
+			Pos:         relPath(fset.Position(pos.Pos()).String()),
+		}
+
+		for _, body := range []struct {
+			expr    string
+			typ     *string
+			imports *[]string
+			schema  **Schema
+		}{
+			{info.Request, &api.RequestType, &api.RequestImports, &api.Request},
+			{info.Response, &api.ResponseType, &api.ResponseImports, &api.Response},
+		} {
+			if body.expr == "" || body.expr == NoBody {
+				continue
+			}
+			typ, paths, schema, err := resolver.resolve(body.expr)
+			if err != nil {
+				return nil, errors.Wrapf(err, "api %s: invalid type %s", api.Path, body.expr)
+			}
+			*body.typ, *body.schema = typ, schema
+			seen := map[string]bool{}
+			for _, path := range paths {
+				if !seen[path] {
+					seen[path] = true
+					*body.imports = append(*body.imports, path)
+				}
+			}
+		}
+		m.APIs = append(m.APIs, api)
+	}
+	return m, nil
+}
+
+// apiDecl is an annotated declaration of the type file: a type group
+// annotated as a whole, or a single annotated type spec.
+type apiDecl struct {
+	info ApiInfo // Request and Response resolved by the conventions
+	doc  *ast.CommentGroup
+	decl *ast.GenDecl
+	spec *ast.TypeSpec // annotated spec, nil when the group is annotated
+}
+
+// apiDecls returns the annotated declarations of the file in order. An
+// annotation above a type group applies to the group, and one above a spec
+// of a group or an ungrouped type applies to that spec only.
+//
+// Unless set by @request and @response, the bodies of an annotated group are
+// its Req and Resp suffixed structs; other structs of the group are helper
+// types and never assigned. An annotated spec is the response if its name
+// ends with Resp and the request otherwise, its counterpart being the
+// struct of the file with the other suffix, like LoginResp for LoginReq.
+func apiDecls(file *ast.File) []apiDecl {
+	structs := map[string]bool{}
+	for _, decl := range file.Decls {
+		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.TYPE {
+			for _, spec := range genDecl.Specs {
+				if typeSpec := spec.(*ast.TypeSpec); isStruct(typeSpec.Type) {
+					structs[typeSpec.Name.Name] = true
+				}
+			}
+		}
+	}
+
+	var decls []apiDecl
+	add := func(doc *ast.CommentGroup, decl *ast.GenDecl, spec *ast.TypeSpec) {
+		if doc == nil {
+			return
+		}
+		info := ParseComments(doc.Text())
+		if info.Path == "" {
+			return
+		}
+		if spec != nil {
+			specBodies(&info, spec.Name.Name, structs)
+		} else {
+			groupBodies(&info, decl)
+		}
+		decls = append(decls, apiDecl{info: info, doc: doc, decl: decl, spec: spec})
+	}
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+		// 单独声明的类型，注解属于该类型
+		if !genDecl.Lparen.IsValid() {
+			add(genDecl.Doc, genDecl, genDecl.Specs[0].(*ast.TypeSpec))
+			continue
+		}
+		add(genDecl.Doc, genDecl, nil)
+		for _, spec := range genDecl.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+			add(typeSpec.Doc, genDecl, typeSpec)
+		}
+	}
+	return decls
+}
+
+// groupBodies picks the Req and Resp suffixed structs of the group.
+func groupBodies(info *ApiInfo, decl *ast.GenDecl) {
+	for _, spec := range decl.Specs {
+		typeSpec := spec.(*ast.TypeSpec)
+		if !isStruct(typeSpec.Type) {
+			continue
+		}
+		name := typeSpec.Name.Name
+		switch {
+		case info.Request == "" && strings.HasSuffix(name, "Req"):
+			info.Request = name
+		case info.Response == "" && strings.HasSuffix(name, "Resp"):
+			info.Response = name
+		}
+	}
+}
+
+// specBodies uses the annotated spec as request or response, and the struct
+// with the other suffix as its counterpart.
+func specBodies(info *ApiInfo, name string, structs map[string]bool) {
+	if base, ok := strings.CutSuffix(name, "Resp"); ok {
+		if info.Response == "" {
+			info.Response = name
+		}
+		if info.Request == "" && structs[base+"Req"] {
+			info.Request = base + "Req"
+		}
+		return
+	}
+	if info.Request == "" {
+		info.Request = name
+	}
+	if base, ok := strings.CutSuffix(name, "Req"); ok && info.Response == "" && structs[base+"Resp"] {
+		info.Response = base + "Resp"
+	}
+}
+
+// modelParser builds schemas from the syntax of the type file alone.
+type modelParser struct {
+	pkg     string
+	path    string                   // import path of the package, if known
+	imports map[string]string        // package name -> import path
+	structs map[string]*ast.TypeSpec // struct types of the file by name
+}
+
+func newModelParser(file *ast.File, path string) *modelParser {
+	p := &modelParser{pkg: file.Name.Name, path: path, imports: map[string]string{}, structs: map[string]*ast.TypeSpec{}}
+	for _, spec := range file.Imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		name := importPath[strings.LastIndex(importPath, "/")+1:]
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		p.imports[name] = importPath
+	}
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+		specDoc(genDecl)
+		for _, spec := range genDecl.Specs {
+			if typeSpec := spec.(*ast.TypeSpec); isStruct(typeSpec.Type) {
+				p.structs[typeSpec.Name.Name] = typeSpec
+			}
+		}
+	}
+	return p
+}
+
+func (p *modelParser) resolve(expr string) (string, []string, *Schema, error) {
+	e, err := parser.ParseExpr(expr)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	schema := p.resolveExpr(e, map[string]bool{})
+
+	var imports []string
+	var qualify func(e ast.Expr) error
+	qualify = func(e ast.Expr) error {
+		switch t := e.(type) {
+		case *ast.Ident:
+			if types.Universe.Lookup(t.Name) != nil {
+				return nil
+			}
+			if p.path != "" {
+				imports = append(imports, p.path)
+			}
+			t.Name = p.pkg + "." + t.Name
+		case *ast.SelectorExpr:
+			var path string
+			if x, ok := t.X.(*ast.Ident); ok {
+				path = p.imports[x.Name]
+			}
+			if path == "" {
+				return errors.Errorf("unknown package %s", types.ExprString(t.X))
+			}
+			imports = append(imports, path)
+		case *ast.StarExpr:
+			return qualify(t.X)
+		case *ast.ArrayType:
+			return qualify(t.Elt)
+		case *ast.MapType:
+			if err := qualify(t.Key); err != nil {
+				return err
+			}
+			return qualify(t.Value)
+		default:
+			return errors.New("unsupported type expression")
+		}
+		return nil
+	}
+	if err := qualify(e); err != nil {
+		return "", nil, nil, err
+	}
+	return types.ExprString(e), imports, schema, nil
+}
+
+func (p *modelParser) structSchema(spec *ast.TypeSpec, seen map[string]bool) *Schema {
+	seen[spec.Name.Name] = true
+	defer delete(seen, spec.Name.Name)
+
+	s := p.fields(spec.Type.(*ast.StructType), seen)
+	s.Name, s.Pkg, s.Comment = spec.Name.Name, p.pkg, commentText(spec.Doc, spec.Comment)
+	return s
+}
+
+func (p *modelParser) fields(st *ast.StructType, seen map[string]bool) *Schema {
+	s := &Schema{Fields: []Field{}}
+	for _, field := range st.Fields.List {
+		f := Field{Type: types.ExprString(field.Type)}
+		if field.Tag != nil {
+			f.Tag, _ = strconv.Unquote(field.Tag.Value)
+			f.Tags = parseTags(f.Tag)
+			f.Required = isRequired(f.Tags)
+		}
+		f.Comment = commentText(field.Doc, field.Comment)
+		f.Schema = p.resolveExpr(field.Type, seen)
+
+		if len(field.Names) == 0 {
+			f.Embedded = true
+			if ident := baseIdent(field.Type); ident != nil {
+				f.Name = ident.Name
+			}
+			s.Fields = append(s.Fields, f)
+		}
+		for _, name := range field.Names {
+			// 未导出的字段不参与序列化
+			if !name.IsExported() {
+				continue
+			}
+			f.Name = name.Name
+			s.Fields = append(s.Fields, f)
+		}
+	}
+	return s
+}
+
+// resolveExpr returns the schema of the struct type an expression refers to
+// through pointers, slices, arrays and map values.
+func (p *modelParser) resolveExpr(expr ast.Expr, seen map[string]bool) *Schema {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return p.resolveExpr(t.X, seen)
+	case *ast.ArrayType:
+		return p.resolveExpr(t.Elt, seen)
+	case *ast.MapType:
+		return p.resolveExpr(t.Value, seen)
+	case *ast.StructType:
+		return p.fields(t, seen)
+	case *ast.Ident:
+		if spec, ok := p.structs[t.Name]; ok && !seen[t.Name] {
+			return p.structSchema(spec, seen)
+		}
+	}
+	return nil
+}
+
+func isStruct(expr ast.Expr) bool {
+	_, ok := expr.(*ast.StructType)
+	return ok
+}
+
+// docText returns the doc comment without the annotation lines.
+func docText(doc *ast.CommentGroup) string {
+	var lines []string
+	for _, line := range strings.Split(doc.Text(), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "@") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseTags parses a struct tag into its key-value pairs.
+func parseTags(tag string) map[string]string {
+	tags := map[string]string{}
+	for tag != "" {
+		tag = strings.TrimLeft(tag, " ")
+		i := strings.Index(tag, `:"`)
+		if i <= 0 {
+			break
+		}
+		key := tag[:i]
+		rest := tag[i+1:]
+		// 找到值的结束引号，跳过转义字符
+		j := 1
+		for j < len(rest) && rest[j] != '"' {
+			if rest[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		if j >= len(rest) {
+			break
+		}
+		value, err := strconv.Unquote(rest[:j+1])
+		if err != nil {
+			break
+		}
+		tags[key] = value
+		tag = rest[j+1:]
+	}
+	return tags
+}
+
+// isRequired reports whether the binding tag requires the field. gin checks
+// only the binding tag, validate tags need a validator of their own.
+func isRequired(tags map[string]string) bool {
+	for _, rule := range strings.Split(tags["binding"], ",") {
+		if rule == "required" {
+			return true
+		}
+	}
+	return false
+}
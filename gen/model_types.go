@@ -0,0 +1,309 @@
+package gen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/packages"
+)
+
+// typesResolver builds schemas from the type-checked package of the type
+// file, so that types of other files and packages are resolved too.
+type typesResolver struct {
+	pkg      *types.Package
+	fset     *token.FileSet
+	filePos  token.Pos         // position in the type file, for its imports
+	root     string            // root of the module, whose files are parsed for comments
+	parsed   map[string]bool   // files whose comments are collected
+	comments map[string]string // file and line of a type or field name -> comment
+}
+
+// loadTypes type-checks the package of the type file, with src as its
+// content when it is not nil. Its dependencies come from export data; the
+// files of the module that declare the types used are parsed for comments
+// when needed, dependencies outside the module carry no comments.
+func loadTypes(filename string, src []byte) (*typesResolver, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	root, err := moduleRoot(filepath.Dir(abs))
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes,
+		Dir:  root,
+	}
+	if src != nil {
+		cfg.Overlay = map[string][]byte{abs: src}
+	}
+	pkgs, err := packages.Load(cfg, "file="+abs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load packages")
+	}
+
+	r := &typesResolver{root: root, parsed: map[string]bool{}, comments: map[string]string{}}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Syntax {
+			if pkg.Fset.Position(file.Pos()).Filename == abs {
+				r.pkg, r.fset, r.filePos = pkg.Types, pkg.Fset, file.Name.Pos()
+			}
+			r.addComments(pkg.Fset, file)
+		}
+	}
+	if r.pkg == nil {
+		return nil, errors.Errorf("package of %s not found", filename)
+	}
+	return r, nil
+}
+
+// addComments collects the comments of the types and fields of file.
+func (r *typesResolver) addComments(fset *token.FileSet, file *ast.File) {
+	r.parsed[fset.Position(file.Pos()).Filename] = true
+	add := func(pos token.Pos, c string) {
+		p := fset.Position(pos)
+		r.comments[fmt.Sprintf("%s:%d", p.Filename, p.Line)] = c
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.GenDecl:
+			specDoc(n)
+		case *ast.TypeSpec:
+			if c := commentText(n.Doc, n.Comment); c != "" {
+				add(n.Name.Pos(), c)
+			}
+		case *ast.Field:
+			c := commentText(n.Doc, n.Comment)
+			if c == "" {
+				return true
+			}
+			if len(n.Names) == 0 {
+				if ident := baseIdent(n.Type); ident != nil {
+					add(ident.Pos(), c)
+				}
+			}
+			for _, name := range n.Names {
+				add(name.Pos(), c)
+			}
+		}
+		return true
+	})
+}
+
+func (r *typesResolver) resolve(expr string) (string, []string, *Schema, error) {
+	// 在类型文件的作用域内求值，以便使用其导入的包
+	tv, err := types.Eval(r.fset, r.pkg, r.filePos, expr)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if !tv.IsType() {
+		return "", nil, nil, errors.Errorf("%s is not a type", expr)
+	}
+
+	var imports []string
+	typ := types.TypeString(tv.Type, func(pkg *types.Package) string {
+		imports = append(imports, pkg.Path())
+		return pkg.Name()
+	})
+	return typ, imports, r.schemaOf(tv.Type, map[*types.TypeName]bool{}), nil
+}
+
+// schemaOf returns the schema of the struct type t refers to through
+// pointers, slices, arrays and map values.
+func (r *typesResolver) schemaOf(t types.Type, seen map[*types.TypeName]bool) *Schema {
+	switch t := types.Unalias(t).(type) {
+	case *types.Pointer:
+		return r.schemaOf(t.Elem(), seen)
+	case *types.Slice:
+		return r.schemaOf(t.Elem(), seen)
+	case *types.Array:
+		return r.schemaOf(t.Elem(), seen)
+	case *types.Map:
+		return r.schemaOf(t.Elem(), seen)
+	case *types.Struct:
+		return r.fields(t, seen)
+	case *types.Named:
+		st, ok := t.Underlying().(*types.Struct)
+		obj := t.Obj()
+		if !ok || seen[obj] {
+			return nil
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+
+		s := r.fields(st, seen)
+		s.Name, s.Comment = obj.Name(), r.comment(obj)
+		if obj.Pkg() != nil {
+			s.Pkg, s.PkgPath = obj.Pkg().Name(), obj.Pkg().Path()
+		}
+		return s
+	}
+	return nil
+}
+
+func (r *typesResolver) fields(st *types.Struct, seen map[*types.TypeName]bool) *Schema {
+	s := &Schema{Fields: []Field{}}
+	for i := 0; i < st.NumFields(); i++ {
+		v := st.Field(i)
+		// 未导出的字段不参与序列化
+		if !v.Exported() && !v.Embedded() {
+			continue
+		}
+		f := Field{
+			Name:     v.Name(),
+			Type:     types.TypeString(v.Type(), r.qualifier),
+			Tag:      st.Tag(i),
+			Comment:  r.comment(v),
+			Embedded: v.Embedded(),
+			Schema:   r.schemaOf(v.Type(), seen),
+			Enum:     enumValues(v.Type()),
+			Basic:    basicOf(v.Type()),
+		}
+		if f.Tag != "" {
+			f.Tags = parseTags(f.Tag)
+			f.Required = isRequired(f.Tags)
+		}
+		s.Fields = append(s.Fields, f)
+	}
+	return s
+}
+
+// qualifier qualifies types of other packages by their package name.
+func (r *typesResolver) qualifier(pkg *types.Package) string {
+	if pkg == r.pkg {
+		return ""
+	}
+	return pkg.Name()
+}
+
+// comment returns the comment of a type or field. Export data keeps only
+// the file and line of objects, so comments are looked up by line, and the
+// files of the module are parsed the first time one of their objects is.
+func (r *typesResolver) comment(obj types.Object) string {
+	p := r.fset.Position(obj.Pos())
+	if p.Filename == "" {
+		return ""
+	}
+	if rel, err := filepath.Rel(r.root, p.Filename); err == nil && !r.parsed[p.Filename] && !strings.HasPrefix(rel, "..") {
+		r.parsed[p.Filename] = true
+		fset := token.NewFileSet()
+		if file, err := parser.ParseFile(fset, p.Filename, nil, parser.ParseComments); err == nil {
+			r.addComments(fset, file)
+		}
+	}
+	return r.comments[fmt.Sprintf("%s:%d", p.Filename, p.Line)]
+}
+
+// enumValues returns the values of the constants declared with the named
+// basic type t refers to, like the values of type Status int.
+func enumValues(t types.Type) []string {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	named, ok := types.Unalias(t).(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return nil
+	}
+	if _, ok := named.Underlying().(*types.Basic); !ok {
+		return nil
+	}
+
+	type enum struct {
+		pos   token.Pos
+		value string
+	}
+	var enums []enum
+	scope := named.Obj().Pkg().Scope()
+	for _, name := range scope.Names() {
+		if c, ok := scope.Lookup(name).(*types.Const); ok && types.Identical(c.Type(), named) {
+			value := c.Val().ExactString()
+			if c.Val().Kind() == constant.String {
+				value = constant.StringVal(c.Val())
+			}
+			enums = append(enums, enum{c.Pos(), value})
+		}
+	}
+	// 按声明顺序排列
+	sort.SliceStable(enums, func(i, j int) bool { return enums[i].pos < enums[j].pos })
+
+	values := make([]string, 0, len(enums))
+	for _, e := range enums {
+		values = append(values, e.value)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// basicOf returns the underlying basic type of the named type t refers to
+// through pointers, slices, arrays and map values, like schemaOf follows
+// them.
+func basicOf(t types.Type) string {
+	switch t := types.Unalias(t).(type) {
+	case *types.Pointer:
+		return basicOf(t.Elem())
+	case *types.Slice:
+		return basicOf(t.Elem())
+	case *types.Array:
+		return basicOf(t.Elem())
+	case *types.Map:
+		return basicOf(t.Elem())
+	case *types.Named:
+		if b, ok := t.Underlying().(*types.Basic); ok {
+			return b.Name()
+		}
+	}
+	return ""
+}
+
+// baseIdent returns the identifier naming an embedded type, like Base for
+// *pkg.Base.
+func baseIdent(expr ast.Expr) *ast.Ident {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return baseIdent(t.X)
+	case *ast.SelectorExpr:
+		return t.Sel
+	case *ast.IndexExpr:
+		return baseIdent(t.X)
+	case *ast.IndexListExpr:
+		return baseIdent(t.X)
+	case *ast.Ident:
+		return t
+	}
+	return nil
+}
+
+// specDoc moves the doc comment of an ungrouped type declaration, like
+// type User struct, to its spec.
+func specDoc(decl *ast.GenDecl) {
+	if decl.Tok != token.TYPE || decl.Lparen.IsValid() || len(decl.Specs) != 1 {
+		return
+	}
+	if spec := decl.Specs[0].(*ast.TypeSpec); spec.Doc == nil {
+		spec.Doc = decl.Doc
+	}
+}
+
+// commentText returns the doc comment without annotations, or the line
+// comment when there is no doc comment.
+func commentText(doc, line *ast.CommentGroup) string {
+	if doc != nil {
+		return docText(doc)
+	}
+	if line != nil {
+		return strings.TrimSpace(line.Text())
+	}
+	return ""
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/dave/dst"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
@@ -18,59 +19,109 @@ type ApiInfo struct {
 	Auth        bool
 	Group       string
 	Summary     string
+	Middlewares []string
+	Request     string // type of the @request annotation, NoBody for none
+	Response    string // type of the @response annotation, NoBody for none
 }
 
+// NoBody is the type of the @request and @response annotations of apis
+// without a request or response body.
+const NoBody = "none"
+
 func ParseComments(comment string) (info ApiInfo) {
 	info.Auth = true
 	list := strings.Fields(comment)
+	// args returns the n arguments of the annotation at i, or nil when they
+	// are missing, like in an annotation half typed in the editor
+	args := func(i, n int, usage string) []string {
+		if i+n < len(list) {
+			ok := true
+			for _, arg := range list[i+1 : i+1+n] {
+				ok = ok && !strings.HasPrefix(arg, "@")
+			}
+			if ok {
+				return list[i+1 : i+1+n]
+			}
+		}
+		logrus.Warningf("%s requires %s: %s", list[i], usage, annotationLine(comment, list[i]))
+		return nil
+	}
 	for i := 0; i < len(list); i++ {
 		switch strings.ToLower(list[i]) {
 		case "@handler":
-			info.HandlerName = cases.Title(language.English).String(list[i+1])
+			if arg := args(i, 1, "a handler name"); arg != nil {
+				info.HandlerName = cases.Title(language.English, cases.NoLower).String(arg[0])
+			}
 		case "@router":
-			info.Path = list[i+1]
-			info.Method = strings.Trim(strings.ToUpper(list[i+2]), "[]")
+			if arg := args(i, 2, "a path and a [method]"); arg != nil {
+				info.Path = arg[0]
+				info.Method = strings.Trim(strings.ToUpper(arg[1]), "[]")
+			}
 		case "@auth":
-			if list[i+1] == "false" {
+			if arg := args(i, 1, "true or false"); arg != nil && arg[0] == "false" {
 				info.Auth = false
 			}
 		case "@group":
-			info.Group = list[i+1]
+			if arg := args(i, 1, "a group path"); arg != nil {
+				info.Group = arg[0]
+			}
 		case "@summary":
-			info.Summary = list[i+1]
+			if arg := args(i, 1, "a summary"); arg != nil {
+				info.Summary = arg[0]
+			}
+		case "@request":
+			if arg := args(i, 1, "a type or "+NoBody); arg != nil {
+				info.Request = arg[0]
+			}
+		case "@response":
+			if arg := args(i, 1, "a type or "+NoBody); arg != nil {
+				info.Response = arg[0]
+			}
+		case "@middleware":
+			arg := args(i, 1, "a comma-separated list of middleware names")
+			if arg == nil {
+				continue
+			}
+			for _, name := range strings.Split(arg[0], ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					info.Middlewares = append(info.Middlewares, name)
+				}
+			}
 		}
 	}
 	return
 }
 
-type TypeInfo struct {
-	Req     string
-	Resp    string
-	PkgName string
-	ApiInfo
-}
-
-func parseStructs(pkgName string, structNames []string) (r TypeInfo) {
-	for _, v := range structNames {
-		if suffix := strings.TrimSuffix(v, "Req"); suffix != v {
-			r.Req = pkgName + "." + v
-		} else if suffix := strings.TrimSuffix(v, "Resp"); suffix != v {
-			r.Resp = pkgName + "." + v
+// annotationLine returns the line of the comment holding the annotation.
+func annotationLine(comment, annotation string) string {
+	for _, line := range strings.Split(comment, "\n") {
+		if strings.Contains(line, annotation) {
+			return strings.TrimSpace(line)
 		}
 	}
-	r.PkgName = pkgName
-	return
+	return annotation
+}
+
+type TypeInfo struct {
+	Req         string // qualified Go type, like types.LoginReq, empty without request
+	Resp        string // qualified Go type, like []types.Item, empty without response
+	PkgName     string
+	ReqImports  []string // import paths of the packages used by Req
+	RespImports []string // import paths of the packages used by Resp
+	ApiInfo
 }
 
 type FuncInfo struct {
 	Pkg      string
 	FuncName string
+	Recv     string
 	Results  []string
 }
 
 func parseFunc(pkg string, dec *dst.FuncDecl) (l FuncInfo) {
 	l.Pkg = pkg
 	l.FuncName = dec.Name.Name
+	l.Recv = recvType(dec)
 	results := dec.Type.Results
 	result := []string{}
 	if results != nil {
@@ -84,6 +135,23 @@ func parseFunc(pkg string, dec *dst.FuncDecl) (l FuncInfo) {
 	return
 }
 
+// recvType returns the receiver type of the function declaration, like
+// "*UserLogic", or an empty string for plain functions.
+func recvType(dec *dst.FuncDecl) string {
+	if dec.Recv == nil || len(dec.Recv.List) == 0 {
+		return ""
+	}
+	switch t := dec.Recv.List[0].Type.(type) {
+	case *dst.Ident:
+		return t.Name
+	case *dst.StarExpr:
+		if ident, ok := t.X.(*dst.Ident); ok {
+			return "*" + ident.Name
+		}
+	}
+	return ""
+}
+
 func parseCodeTmp(code string) (*ast.FuncDecl, error) {
 	code = "package main\n\n" + code
 	fset := token.NewFileSet()
@@ -105,37 +173,30 @@ func parseCodeTmp(code string) (*ast.FuncDecl, error) {
 }
 
 func parseTypes(filename, path string) (info TypeInfo) {
-	var apiInfo ApiInfo
-	var types []string
-
-	fset := token.NewFileSet()
-
-	astFile, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	apis, err := parseAllTypes(filename)
 	if err != nil {
 		panic(err)
 	}
 
-	for _, decl := range astFile.Decls {
-		if v, ok := decl.(*ast.GenDecl); ok {
-			for _, spec := range v.Specs {
-				if typeSpec, ok := spec.(*ast.TypeSpec); ok {
-					if _, ok := typeSpec.Type.(*ast.StructType); ok {
-						if v.Doc != nil {
-							// structName := typeSpec.Name.Name
-							commentText := v.Doc.Text()
-							info := ParseComments(commentText)
-							if info.Path == path {
-								apiInfo = info
-								types = append(types, typeSpec.Name.Name)
-							}
-						}
-					}
-				}
-			}
-
+	for _, api := range apis {
+		if api.Path == path {
+			return api
 		}
 	}
-	info = parseStructs(astFile.Name.Name, types)
-	info.ApiInfo = apiInfo
 	return
 }
+
+// parseAllTypes parses every annotated api of the type file, in the order
+// they are declared, in the form used by the code templates.
+func parseAllTypes(filename string) ([]TypeInfo, error) {
+	m, err := ParseModel(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	apis := make([]TypeInfo, 0, len(m.APIs))
+	for _, api := range m.APIs {
+		apis = append(apis, api.TypeInfo(m.Package))
+	}
+	return apis, nil
+}
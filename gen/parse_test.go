@@ -0,0 +1,47 @@
+package gen
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseComments(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		want    ApiInfo
+	}{
+		{
+			name:    "full annotations",
+			comment: "@handler getUserInfo\n@auth false\n@group user\n@middleware cors,log\n@request none\n@router /user/:id [get]",
+			want:    ApiInfo{Path: "/user/:id", Method: "GET", HandlerName: "GetUserInfo", Group: "user", Middlewares: []string{"cors", "log"}, Request: NoBody},
+		},
+		{
+			name:    "router without method",
+			comment: "@handler login\n@router /x",
+			want:    ApiInfo{HandlerName: "Login", Auth: true},
+		},
+		{
+			name:    "router followed by another annotation",
+			comment: "@router /x\n@auth false",
+			want:    ApiInfo{},
+		},
+		{
+			name:    "bare trailing annotations",
+			comment: "@router /x [post]\n@summary",
+			want:    ApiInfo{Path: "/x", Method: "POST", Auth: true},
+		},
+		{name: "bare handler", comment: "@handler", want: ApiInfo{Auth: true}},
+		{name: "bare auth", comment: "@auth", want: ApiInfo{Auth: true}},
+		{name: "bare group", comment: "@group", want: ApiInfo{Auth: true}},
+		{name: "bare middleware", comment: "@middleware\n@auth false", want: ApiInfo{}},
+		{name: "bare response", comment: "@response", want: ApiInfo{Auth: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseComments(tt.comment); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseComments() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
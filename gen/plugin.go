@@ -0,0 +1,163 @@
+package gen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Plugin is an executable extending the generation, like a protoc plugin.
+// It reads a PluginRequest as JSON from stdin and writes a PluginResponse as
+// JSON to stdout. Its stderr is passed through.
+type Plugin struct {
+	Name string   `yaml:"name" json:"name"` // stage name, used by the stages config
+	Cmd  string   `yaml:"cmd" json:"cmd"`
+	Args []string `yaml:"args" json:"args"`
+}
+
+// PluginRequest is the model sent to plugins.
+type PluginRequest struct {
+	API    string     `json:"api"`    // path of the api being generated
+	APIs   []API      `json:"apis"`   // every annotated api of the type file
+	Routes *RouteNode `json:"routes"` // route tree of the router function
+	Config Config     `json:"config"`
+}
+
+// PluginResponse lists the edits of a plugin, or the error it failed with.
+type PluginResponse struct {
+	Error string     `json:"error,omitempty"`
+	Edits []FileEdit `json:"edits"`
+}
+
+// Operations of a FileEdit.
+const (
+	EditCreateFile = "create-file" // create File with Content, replaced only if Overwrite is set
+	EditAppendDecl = "append-decl" // add the declarations of Content to the Go file like generated code
+)
+
+// FileEdit is a change of a file requested by a plugin. Edits are applied in
+// order to the transaction of the api, so a file created by an edit can be
+// extended by the following ones.
+type FileEdit struct {
+	Op        string   `json:"op"`
+	File      string   `json:"file"` // relative to the working directory
+	Content   string   `json:"content"`
+	Imports   []string `json:"imports,omitempty"`   // import paths added by append-decl
+	Overwrite bool     `json:"overwrite,omitempty"` // create-file replaces an existing file
+}
+
+// pluginStage runs a plugin for each api.
+type pluginStage struct {
+	plugin Plugin
+}
+
+func (s pluginStage) Name() string { return s.plugin.Name }
+
+func (s pluginStage) Handle(b *APIGenBuilder) error {
+	req, err := newPluginRequest(b)
+	if err != nil {
+		return err
+	}
+	resp, err := s.plugin.run(req)
+	if err != nil {
+		return err
+	}
+	for _, edit := range resp.Edits {
+		if err := applyEdit(b.tx, edit); err != nil {
+			return errors.Wrapf(err, "%s %s", edit.Op, edit.File)
+		}
+	}
+	return nil
+}
+
+// pluginStages returns the stages of the plugins of the config.
+func pluginStages(cfg Config) ([]Stage, error) {
+	var stages []Stage
+	for _, p := range cfg.Plugins {
+		if p.Name == "" || p.Cmd == "" {
+			return nil, errors.New("plugins need a name and a cmd")
+		}
+		stages = append(stages, pluginStage{plugin: p})
+	}
+	return stages, nil
+}
+
+func newPluginRequest(b *APIGenBuilder) (*PluginRequest, error) {
+	m := b.model
+	if m == nil {
+		var err error
+		if m, err = ParseModel(b.cfg.TypeFile); err != nil {
+			return nil, err
+		}
+	}
+	req := &PluginRequest{API: b.api, APIs: m.APIs, Config: b.cfg}
+
+	// 路由树包含之前阶段暂存的修改
+	file, err := b.tx.ParseFile(b.cfg.Router.File)
+	if err != nil {
+		return nil, err
+	}
+	fn, err := findFunc(file, b.cfg.Router.GroupFunc)
+	if err != nil {
+		return nil, err
+	}
+	req.Routes = buildRouteTree(fn)
+	return req, nil
+}
+
+// run executes the plugin with the request.
+func (p Plugin) run(req *PluginRequest) (*PluginResponse, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command(p.Cmd, p.Args...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "plugin %s failed", p.Name)
+	}
+
+	var resp PluginResponse
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		return nil, errors.Wrapf(err, "invalid response of plugin %s", p.Name)
+	}
+	if resp.Error != "" {
+		return nil, errors.Errorf("plugin %s: %s", p.Name, resp.Error)
+	}
+	return &resp, nil
+}
+
+// applyEdit applies the edit of a plugin to the transaction. Edits may only
+// touch files below the working directory.
+func applyEdit(tx *Tx, edit FileEdit) error {
+	file := filepath.Clean(edit.File)
+	if edit.File == "" || filepath.IsAbs(file) || file == ".." || strings.HasPrefix(file, ".."+string(filepath.Separator)) {
+		return errors.New("file must be a relative path below the working directory")
+	}
+
+	switch edit.Op {
+	case EditCreateFile:
+		if _, err := tx.ReadFile(file); err == nil && !edit.Overwrite {
+			fmt.Println("File", file, "already exists. Skipping...")
+			return nil
+		}
+		tx.WriteFile(file, []byte(edit.Content))
+		fmt.Println("File", file, "will be written.")
+		return nil
+	case EditAppendDecl:
+		_, err := writeDecl(tx, file, edit.Content, edit.Imports...)
+		return err
+	default:
+		return errors.Errorf("unknown op %q", edit.Op)
+	}
+}
@@ -0,0 +1,541 @@
+package gen
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var protoTmp = `// Code generated by api-gen. DO NOT EDIT.
+
+syntax = "proto3";
+
+package {{ .Package }};
+{{ if .GoPackage }}
+option go_package = "{{ .GoPackage }}";
+{{ end }}
+{{- range .Imports }}
+import "{{ . }}";
+{{- end }}
+{{ range .Services }}
+service {{ .Name }} {
+{{- range $i, $rpc := .RPCs }}{{ if $i }}
+{{ end }}
+{{ comment $rpc.Comment "  " }}  rpc {{ .Name }}({{ .Request }}) returns ({{ .Response }}) {
+    option (google.api.http) = {
+      {{ if .Custom }}custom: { kind: "{{ .Method }}" path: "{{ .Path }}" }{{ else }}{{ .Method }}: "{{ .Path }}"{{ end }}{{ if .Body }}
+      body: "{{ .Body }}"{{ end }}
+    };
+  }
+{{- end }}
+}
+{{ end }}
+{{- range .Messages }}
+{{ comment .Comment "" }}message {{ .Name }} {
+{{- range .Reserved }}
+  reserved {{ . }};
+{{- end }}
+{{- range .Fields }}
+{{ comment .Comment "  " }}  {{ if .Label }}{{ .Label }} {{ end }}{{ .Type }} {{ .Name }} = {{ .Number }};
+{{- end }}
+}
+{{ end }}`
+
+type protoFile struct {
+	Package   string
+	GoPackage string
+	Imports   []string
+	Services  []*protoService
+	Messages  []*protoMessage
+}
+
+type protoService struct {
+	Name string
+	RPCs []protoRPC
+}
+
+type protoRPC struct {
+	Name, Comment     string
+	Request, Response string
+	Method, Path      string
+	Body              string
+	Custom            bool // method not covered by the http rule, like HEAD
+}
+
+type protoMessage struct {
+	Name, Comment string
+	Fields        []protoField
+	Reserved      []string
+	schema        *Schema // Go struct of the message, nil for wrappers
+}
+
+type protoField struct {
+	Name, Type, Label, Comment string
+	Number                     int
+	goName                     string    // selector of the Go field, like Page.PageNum
+	conv                       *convType // conversion between the Go and proto field
+}
+
+// Kinds of convType.
+const (
+	convSkip     = "" // not converted
+	convNum      = "num"
+	convStr      = "str"
+	convBool     = "bool"
+	convBytes    = "bytes"
+	convTime     = "time"
+	convDuration = "duration"
+	convMsg      = "msg"
+	convPtr      = "ptr"
+	convSlice    = "slice"
+	convMap      = "map"
+)
+
+// convType describes how a Go type converts to the proto type it maps to.
+type convType struct {
+	kind      string
+	elem, key *convType
+	msg       string // message of convMsg
+}
+
+// protoLock is the lock file keeping the numbers of the message fields
+// stable. Removed fields stay in the lock so that their numbers are
+// reserved and never reused.
+type protoLock struct {
+	Messages map[string]map[string]int `json:"messages"`
+}
+
+// Go 类型对应的 proto 标量类型
+var protoScalars = map[string]string{
+	"string": "string", "bool": "bool",
+	"int": "int64", "int64": "int64", "int32": "int32", "int16": "int32", "int8": "int32",
+	"uint": "uint64", "uint64": "uint64", "uint32": "uint32", "uint16": "uint32", "uint8": "uint32",
+	"byte": "uint32", "rune": "int32", "float64": "double", "float32": "float",
+}
+
+// Go 类型对应的 well-known 类型及其导入文件
+var protoWellKnown = map[string][2]string{
+	"time.Time":     {"google.protobuf.Timestamp", "google/protobuf/timestamp.proto"},
+	"time.Duration": {"google.protobuf.Duration", "google/protobuf/duration.proto"},
+	"interface{}":   {"google.protobuf.Value", "google/protobuf/struct.proto"},
+	"any":           {"google.protobuf.Value", "google/protobuf/struct.proto"},
+}
+
+var protoPathParam = regexp.MustCompile(`([:*])([A-Za-z0-9_]+)`)
+
+// GenProto writes a proto file with a service per @group of the annotated
+// apis of the config, and returns the written files. Field numbers are kept
+// in the lock file of the config.
+func GenProto(cfg Config) ([]string, error) {
+	m, err := ParseModel(cfg.TypeFile)
+	if err != nil {
+		return nil, err
+	}
+	dir := cfg.Proto.Dir
+	if dir == "" {
+		dir = "proto"
+	}
+	lockFile := cfg.Proto.Lock
+	if lockFile == "" {
+		lockFile = filepath.Join(dir, "proto.lock")
+	}
+	lock := &protoLock{Messages: map[string]map[string]int{}}
+	if data, err := os.ReadFile(lockFile); err == nil {
+		if err := json.Unmarshal(data, lock); err != nil {
+			return nil, errors.Wrapf(err, "invalid lock file %s", lockFile)
+		}
+	}
+
+	g := newProtoGen(cfg, m, lock)
+	file, err := g.file()
+	if err != nil {
+		return nil, err
+	}
+	content, err := execTemplate(protoTmp, file)
+	if err != nil {
+		return nil, err
+	}
+	lockData, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	protoFile := filepath.Join(dir, file.Package+".proto")
+	tx := NewTx()
+	tx.WriteFile(protoFile, []byte(content))
+	tx.WriteFile(lockFile, append(lockData, '\n'))
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return []string{protoFile, lockFile}, nil
+}
+
+// protoGen converts the model into proto services and messages.
+type protoGen struct {
+	cfg      Config
+	model    *Model
+	lock     *protoLock
+	messages map[string]*protoMessage
+	order    []*protoMessage
+	imports  map[string]bool
+}
+
+func newProtoGen(cfg Config, m *Model, lock *protoLock) *protoGen {
+	return &protoGen{cfg: cfg, model: m, lock: lock, messages: map[string]*protoMessage{}, imports: map[string]bool{"google/api/annotations.proto": true}}
+}
+
+func (g *protoGen) file() (*protoFile, error) {
+	file := &protoFile{Package: g.cfg.Proto.Package, GoPackage: g.cfg.Proto.GoPackage}
+	if file.Package == "" {
+		file.Package = g.model.Package
+	}
+
+	mount, err := routerMount(g.cfg)
+	if err != nil {
+		logrus.Warningf("Failed to analyze the routes of the module: %v", err)
+		mount = "/"
+	}
+
+	services := map[string]*protoService{}
+	for _, api := range g.model.APIs {
+		name := protoName(api.Group)
+		if name == "" {
+			name = protoName(g.model.Package)
+		}
+		name += "Service"
+		svc, ok := services[name]
+		if !ok {
+			svc = &protoService{Name: name}
+			services[name] = svc
+			file.Services = append(file.Services, svc)
+		}
+
+		rpc := protoRPC{
+			Name:     protoName(api.Handler),
+			Comment:  strings.TrimSpace(api.Summary + "\n" + api.Comment),
+			Request:  g.body(api.RequestType, api.Request, protoName(api.Handler)+"Request"),
+			Response: g.body(api.ResponseType, api.Response, protoName(api.Handler)+"Response"),
+			Method:   strings.ToLower(api.Method),
+			Path:     protoPathParam.ReplaceAllStringFunc(apiPath(g.cfg, mount, api), protoParam),
+		}
+		switch api.Method {
+		case "GET", "DELETE":
+		case "POST", "PUT", "PATCH":
+			rpc.Body = "*"
+		default:
+			rpc.Custom, rpc.Method, rpc.Body = true, api.Method, "*"
+		}
+		svc.RPCs = append(svc.RPCs, rpc)
+	}
+
+	for path := range g.imports {
+		file.Imports = append(file.Imports, path)
+	}
+	sort.Strings(file.Imports)
+	file.Messages = g.order
+	return file, nil
+}
+
+// protoParam converts a gin path parameter into a path template variable.
+func protoParam(param string) string {
+	if param[0] == '*' {
+		return "{" + param[1:] + "=**}"
+	}
+	return "{" + param[1:] + "}"
+}
+
+// body returns the message of a request or response. Bodies that are not
+// structs are wrapped into a message with a data field.
+func (g *protoGen) body(typ string, s *Schema, wrapper string) string {
+	if typ == "" {
+		g.imports["google/protobuf/empty.proto"] = true
+		return "google.protobuf.Empty"
+	}
+	elem := strings.TrimPrefix(typ, "*")
+	if s != nil && s.Name != "" && !strings.HasPrefix(elem, "[") && !strings.HasPrefix(elem, "map[") {
+		return g.message(s.Name, s)
+	}
+	name := g.message(wrapper, &Schema{Fields: []Field{{
+		Name:   "Data",
+		Type:   typ,
+		Tags:   map[string]string{"json": "data"},
+		Schema: s,
+	}}})
+	g.messages[name].schema = nil
+	return name
+}
+
+// message adds the message of the schema once and returns its name.
+func (g *protoGen) message(name string, s *Schema) string {
+	if _, ok := g.messages[name]; ok {
+		return name
+	}
+	msg := &protoMessage{Name: name, Comment: s.Comment, schema: s}
+	g.messages[name] = msg
+	g.order = append(g.order, msg)
+
+	numbers := g.lock.Messages[name]
+	if numbers == nil {
+		numbers = map[string]int{}
+		g.lock.Messages[name] = numbers
+	}
+	next := 0
+	for _, n := range numbers {
+		next = max(next, n)
+	}
+
+	used := map[string]bool{}
+	for _, f := range g.fields(name, s, "", true) {
+		if used[f.Name] {
+			continue
+		}
+		used[f.Name] = true
+		if n, ok := numbers[f.Name]; ok {
+			f.Number = n
+		} else {
+			next++
+			f.Number, numbers[f.Name] = next, next
+		}
+		msg.Fields = append(msg.Fields, f)
+	}
+
+	// 已删除的字段保留编号，避免被复用
+	var removed []string
+	for field := range numbers {
+		if !used[field] {
+			removed = append(removed, field)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return numbers[removed[i]] < numbers[removed[j]] })
+	for _, field := range removed {
+		msg.Reserved = append(msg.Reserved, strconv.Itoa(numbers[field]), strconv.Quote(field))
+	}
+	return name
+}
+
+// fields returns the fields of the schema, with the fields of embedded
+// structs promoted like encoding/json does. Fields of embedded pointers are
+// not converted, since they may be nil.
+func (g *protoGen) fields(owner string, s *Schema, prefix string, convert bool) []protoField {
+	var fields []protoField
+	for _, f := range s.Fields {
+		name, _, _ := strings.Cut(f.Tags["json"], ",")
+		if name == "" {
+			name, _, _ = strings.Cut(f.Tags["form"], ",")
+		}
+		if name == "-" {
+			continue
+		}
+		if f.Embedded && name == "" && f.Schema != nil {
+			embedded := convert && !strings.HasPrefix(f.Type, "*")
+			fields = append(fields, g.fields(owner, f.Schema, prefix+f.Name+".", embedded)...)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		label, typ := g.fieldType(f, owner+f.Name)
+		field := protoField{Name: protoFieldName(name), Type: typ, Label: label, Comment: f.Comment, goName: prefix + f.Name, conv: &convType{}}
+		if convert {
+			field.conv = g.convType(f.Type, f, owner+f.Name)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// fieldType maps the Go type of a field to its proto label and type.
+func (g *protoGen) fieldType(f Field, anon string) (string, string) {
+	typ := strings.TrimPrefix(f.Type, "*")
+	switch {
+	case typ == "[]byte" || typ == "[]uint8":
+		return "", "bytes"
+	case strings.HasPrefix(typ, "["):
+		elem := elemType(typ)
+		if strings.HasPrefix(elem, "[") || strings.HasPrefix(elem, "map[") {
+			return "", g.wellKnown("interface{}")
+		}
+		return "repeated", g.valueType(elem, f, anon)
+	case strings.HasPrefix(typ, "map["):
+		key := strings.TrimPrefix(typ[:len(typ)-len(elemType(typ))-1], "map[")
+		elem := elemType(typ)
+		if strings.HasPrefix(elem, "[") || strings.HasPrefix(elem, "map[") {
+			return "", g.wellKnown("interface{}")
+		}
+		keyType, ok := protoScalars[key]
+		if !ok || keyType == "double" || keyType == "float" {
+			keyType = "string"
+		}
+		return "", "map<" + keyType + ", " + g.valueType(elem, f, anon) + ">"
+	}
+	t := g.valueType(typ, f, anon)
+	if f.Type != typ && (protoScalars[typ] != "" || protoScalars[f.Basic] != "") {
+		return "optional", t
+	}
+	return "", t
+}
+
+// valueType maps a Go type that is not a slice or map to a proto type.
+func (g *protoGen) valueType(typ string, f Field, anon string) string {
+	typ = strings.TrimPrefix(typ, "*")
+	if scalar, ok := protoScalars[typ]; ok {
+		return scalar
+	}
+	if _, ok := protoWellKnown[typ]; ok {
+		return g.wellKnown(typ)
+	}
+	if f.Schema != nil {
+		name := f.Schema.Name
+		if name == "" {
+			name = anon
+		}
+		return g.message(name, f.Schema)
+	}
+	// 递归引用的结构体沿用已有的消息，具名基础类型按其底层类型
+	base := typ[strings.LastIndex(typ, ".")+1:]
+	if _, ok := g.messages[base]; ok {
+		return base
+	}
+	if scalar, ok := protoScalars[f.Basic]; ok {
+		return scalar
+	}
+	// 没有类型信息时按枚举值推断
+	for _, v := range f.Enum {
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			return "string"
+		}
+	}
+	if len(f.Enum) > 0 {
+		return "int64"
+	}
+	return "string"
+}
+
+// convType returns the conversion of the Go type of a field, following the
+// mapping of fieldType.
+func (g *protoGen) convType(typ string, f Field, anon string) *convType {
+	skip := &convType{}
+	if elem, ok := strings.CutPrefix(typ, "*"); ok {
+		c := g.convType(elem, f, anon)
+		switch c.kind {
+		case convSkip, convBytes, convSlice, convMap:
+			return skip
+		}
+		return &convType{kind: convPtr, elem: c}
+	}
+	switch {
+	case typ == "[]byte" || typ == "[]uint8":
+		return &convType{kind: convBytes}
+	case strings.HasPrefix(typ, "["), strings.HasPrefix(typ, "map["):
+		elem := elemType(typ)
+		if strings.HasPrefix(elem, "[") || strings.HasPrefix(elem, "map[") {
+			return skip
+		}
+		c := &convType{kind: convSlice, elem: g.convType(elem, f, anon)}
+		if strings.HasPrefix(typ, "map[") {
+			key := strings.TrimPrefix(typ[:len(typ)-len(elem)-1], "map[")
+			c.kind, c.key = convMap, g.convType(key, Field{Type: key}, anon)
+			if keyType := protoScalars[key]; keyType == "" || keyType == "double" || keyType == "float" {
+				return skip
+			}
+		}
+		if c.elem.kind == convSkip || c.elem.kind == convBytes {
+			return skip
+		}
+		return c
+	}
+
+	switch t := g.valueType(typ, f, anon); t {
+	case "google.protobuf.Timestamp":
+		return &convType{kind: convTime}
+	case "google.protobuf.Duration":
+		return &convType{kind: convDuration}
+	case "google.protobuf.Value":
+		return skip
+	default:
+		if _, ok := g.messages[t]; ok {
+			// 匿名结构体没有可引用的 Go 类型
+			if f.Schema != nil && f.Schema.Name == "" {
+				return skip
+			}
+			return &convType{kind: convMsg, msg: t}
+		}
+		// 未解析的类型也映射为 string，无法转换的留给 TODO
+		if protoScalars[typ] != t && protoScalars[f.Basic] != t {
+			return skip
+		}
+		switch t {
+		case "string":
+			return &convType{kind: convStr}
+		case "bool":
+			return &convType{kind: convBool}
+		}
+		return &convType{kind: convNum}
+	}
+}
+
+func (g *protoGen) wellKnown(typ string) string {
+	t := protoWellKnown[typ]
+	g.imports[t[1]] = true
+	return t[0]
+}
+
+// protoName converts a name like user_info or user-info into UserInfo.
+func protoName(s string) string {
+	var sb strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+		}
+		sb.WriteRune(r)
+		upper = false
+	}
+	return sb.String()
+}
+
+// protoFieldName converts a name like userName or UserID into user_name or
+// user_id.
+func protoFieldName(s string) string {
+	var sb strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) && runes[i-1] != '_' {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(unicode.ToLower(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
+
+// protoComment renders a comment as proto line comments with the indent.
+func protoComment(comment, indent string) string {
+	if comment == "" {
+		return ""
+	}
+	var sb strings.Builder
+	for _, line := range strings.Split(comment, "\n") {
+		fmt.Fprintf(&sb, "%s// %s\n", indent, line)
+	}
+	return sb.String()
+}
@@ -0,0 +1,559 @@
+package gen
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+const ginPkg = "github.com/gin-gonic/gin"
+
+// ModuleRoutes is the router structure of a whole module. Unlike RouteNode,
+// which only covers one function, it follows router groups passed into other
+// functions across packages and files, so the absolute mount path of every
+// group is known.
+type ModuleRoutes struct {
+	Funcs []*RouterFunc
+
+	fset  *token.FileSet
+	byObj map[*types.Func]*RouterFunc
+}
+
+// RouterFunc describes the router groups used by one function of the module.
+type RouterFunc struct {
+	Name   string // full name, like github.com/ydssx/api-gen/example/router.UserRouter
+	File   string
+	Groups []*RouterGroup
+
+	decl    *ast.FuncDecl
+	info    *types.Info
+	params  map[int]*RouterGroup
+	callers []groupCall
+}
+
+// RouterGroup is a router group in a function: a router group parameter,
+// a gin engine created by gin.New or gin.Default, or a group derived from
+// another one with Group.
+type RouterGroup struct {
+	Path        string // path given to Group
+	Parent      *RouterGroup
+	Param       int  // index of the parameter holding the group, -1 otherwise
+	Root        bool // created by gin.New or gin.Default
+	Middlewares []string
+	Routes      []*Route
+	Mounts      []Mount
+
+	fn   *RouterFunc
+	base []string // middlewares inherited inside the function when the group was created
+}
+
+// Mount is a place a router group is mounted at. Middlewares are the ones
+// applied by the callers of the function owning the group.
+type Mount struct {
+	Path        string
+	Middlewares []string
+}
+
+// Route is a route registration like rg.POST("/login", middleware.Jwt(), handler.Login).
+type Route struct {
+	Method      string   `json:"method"`
+	Path        string   `json:"path"`
+	Handler     string   `json:"handler"`
+	Middlewares []string `json:"middlewares"`
+	Func        string   `json:"func"` // function registering the route
+	Pos         string   `json:"pos"`
+}
+
+// groupCall is a call passing the router group of the caller as a parameter.
+type groupCall struct {
+	group       *RouterGroup
+	param       int
+	middlewares []string
+}
+
+// chain returns the middlewares applied to the group inside its function so far.
+func (g *RouterGroup) chain() []string {
+	return append(append([]string{}, g.base...), g.Middlewares...)
+}
+
+var routeMethods = map[string]string{
+	"GET":     http.MethodGet,
+	"POST":    http.MethodPost,
+	"PUT":     http.MethodPut,
+	"DELETE":  http.MethodDelete,
+	"PATCH":   http.MethodPatch,
+	"HEAD":    http.MethodHead,
+	"OPTIONS": http.MethodOptions,
+	"Any":     "ANY",
+	"Handle":  "",
+}
+
+// AnalyzeModule loads every package of the module containing dir and builds
+// its router structure.
+func AnalyzeModule(dir string) (*ModuleRoutes, error) {
+	root, err := moduleRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
+		Dir:  root,
+	}, "./...")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load packages")
+	}
+
+	m := &ModuleRoutes{byObj: map[*types.Func]*RouterFunc{}}
+	for _, pkg := range pkgs {
+		m.fset = pkg.Fset
+		for _, file := range pkg.Syntax {
+			for _, decl := range file.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Body == nil {
+					continue
+				}
+				obj, ok := pkg.TypesInfo.Defs[fn.Name].(*types.Func)
+				if !ok {
+					continue
+				}
+				rf := &RouterFunc{
+					Name:   obj.FullName(),
+					File:   pkg.Fset.Position(fn.Pos()).Filename,
+					decl:   fn,
+					info:   pkg.TypesInfo,
+					params: map[int]*RouterGroup{},
+				}
+				m.Funcs = append(m.Funcs, rf)
+				m.byObj[obj] = rf
+			}
+		}
+	}
+	sort.Slice(m.Funcs, func(i, j int) bool { return m.Funcs[i].Name < m.Funcs[j].Name })
+
+	for _, fn := range m.Funcs {
+		m.analyzeFunc(fn)
+	}
+	for _, fn := range m.Funcs {
+		for _, g := range fn.Groups {
+			g.Mounts = m.groupMounts(g, map[*RouterFunc]bool{})
+		}
+	}
+	return m, nil
+}
+
+// Func returns the function with the given full name, or nil.
+func (m *ModuleRoutes) Func(name string) *RouterFunc {
+	for _, fn := range m.Funcs {
+		if fn.Name == name {
+			return fn
+		}
+	}
+	return nil
+}
+
+// ParamMounts returns the mounts of the router group passed as the i-th
+// parameter of the function, or nil when nobody calls the function or the
+// parameter is not a router group.
+func (fn *RouterFunc) ParamMounts(i int) []Mount {
+	if g, ok := fn.params[i]; ok {
+		return g.Mounts
+	}
+	return nil
+}
+
+// Routes returns the routes registered by the function at every mount of
+// their groups, with full paths and middlewares.
+func (fn *RouterFunc) Routes() []Route {
+	var routes []Route
+	for _, g := range fn.Groups {
+		for _, mount := range g.Mounts {
+			for _, r := range g.Routes {
+				route := *r
+				route.Path = joinPath(mount.Path, r.Path)
+				route.Middlewares = append(append([]string{}, mount.Middlewares...), r.Middlewares...)
+				routes = append(routes, route)
+			}
+		}
+	}
+	return routes
+}
+
+// Routes returns every route registered in the module, sorted by path and method.
+func (m *ModuleRoutes) Routes() []Route {
+	var routes []Route
+	for _, fn := range m.Funcs {
+		routes = append(routes, fn.Routes()...)
+	}
+	sortRoutes(routes)
+	return routes
+}
+
+func sortRoutes(routes []Route) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+}
+
+func (m *ModuleRoutes) analyzeFunc(fn *RouterFunc) {
+	env := map[types.Object]*RouterGroup{}
+	exprs := map[ast.Expr]*RouterGroup{}
+
+	index := 0
+	for _, field := range fn.decl.Type.Params.List {
+		names := field.Names
+		if len(names) == 0 {
+			names = []*ast.Ident{nil}
+		}
+		for _, name := range names {
+			if isGinRouter(fn.info.TypeOf(field.Type)) {
+				g := &RouterGroup{Param: index, fn: fn}
+				fn.params[index] = g
+				fn.Groups = append(fn.Groups, g)
+				if name != nil {
+					env[fn.info.Defs[name]] = g
+				}
+			}
+			index++
+		}
+	}
+
+	var groupOf func(expr ast.Expr) *RouterGroup
+	groupOf = func(expr ast.Expr) *RouterGroup {
+		if g, ok := exprs[expr]; ok {
+			return g
+		}
+		var g *RouterGroup
+		switch e := expr.(type) {
+		case *ast.ParenExpr:
+			return groupOf(e.X)
+		case *ast.UnaryExpr:
+			return groupOf(e.X)
+		case *ast.Ident:
+			return env[fn.info.Uses[e]]
+		case *ast.SelectorExpr:
+			// engine.RouterGroup
+			if e.Sel.Name == "RouterGroup" {
+				return groupOf(e.X)
+			}
+		case *ast.CallExpr:
+			sel, ok := e.Fun.(*ast.SelectorExpr)
+			if !ok {
+				break
+			}
+			if callee := typeutil.StaticCallee(fn.info, e); callee != nil && callee.Pkg() != nil &&
+				callee.Pkg().Path() == ginPkg && (callee.Name() == "New" || callee.Name() == "Default") {
+				g = &RouterGroup{Param: -1, Root: true, fn: fn}
+			} else if sel.Sel.Name == "Group" && len(e.Args) > 0 {
+				if parent := groupOf(sel.X); parent != nil {
+					g = &RouterGroup{Path: stringValue(fn.info, e.Args[0]), Parent: parent, Param: -1, fn: fn}
+				}
+			}
+		}
+		if g != nil {
+			if g.Parent != nil {
+				g.base = g.Parent.chain()
+			}
+			exprs[expr] = g
+			fn.Groups = append(fn.Groups, g)
+		}
+		return g
+	}
+
+	fset := m.fset
+
+	ast.Inspect(fn.decl.Body, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.AssignStmt:
+			if len(n.Lhs) != len(n.Rhs) {
+				break
+			}
+			for i, rhs := range n.Rhs {
+				ident, ok := n.Lhs[i].(*ast.Ident)
+				if !ok {
+					continue
+				}
+				if g := groupOf(rhs); g != nil {
+					if obj := fn.info.ObjectOf(ident); obj != nil {
+						env[obj] = g
+					}
+				}
+			}
+		case *ast.ValueSpec:
+			for i, value := range n.Values {
+				if i >= len(n.Names) {
+					break
+				}
+				if g := groupOf(value); g != nil {
+					env[fn.info.Defs[n.Names[i]]] = g
+				}
+			}
+		case *ast.CallExpr:
+			if sel, ok := n.Fun.(*ast.SelectorExpr); ok {
+				if g := groupOf(sel.X); g != nil {
+					if sel.Sel.Name == "Use" {
+						for _, arg := range n.Args {
+							g.Middlewares = append(g.Middlewares, types.ExprString(arg))
+						}
+					} else if method, ok := routeMethods[sel.Sel.Name]; ok {
+						if route := newRoute(fn.info, method, n.Args); route != nil {
+							route.Middlewares = append(g.chain(), route.Middlewares...)
+							route.Func = fn.Name
+							pos := fset.Position(n.Pos())
+							pos.Filename = relPath(pos.Filename)
+							route.Pos = pos.String()
+							g.Routes = append(g.Routes, route)
+						}
+					}
+				}
+			}
+
+			callee := typeutil.StaticCallee(fn.info, n)
+			if callee == nil {
+				break
+			}
+			target, ok := m.byObj[callee.Origin()]
+			if !ok {
+				break
+			}
+			for i, arg := range n.Args {
+				if g := groupOf(arg); g != nil {
+					target.callers = append(target.callers, groupCall{group: g, param: i, middlewares: g.chain()})
+				}
+			}
+		}
+		return true
+	})
+}
+
+// newRoute parses the arguments of a route registration. The handler is the
+// last argument and the ones before it are middlewares. Routes whose method
+// or path is not a constant are skipped.
+func newRoute(info *types.Info, method string, args []ast.Expr) *Route {
+	if method == "" {
+		// Handle(httpMethod, relativePath, handlers...)
+		if len(args) < 1 {
+			return nil
+		}
+		value, ok := constString(info, args[0])
+		if !ok {
+			return nil
+		}
+		method, args = strings.ToUpper(value), args[1:]
+	}
+	if len(args) < 1 {
+		return nil
+	}
+	path, ok := constString(info, args[0])
+	if !ok {
+		return nil
+	}
+
+	route := &Route{Method: method, Path: path}
+	handlers := args[1:]
+	for i, arg := range handlers {
+		if i == len(handlers)-1 {
+			route.Handler = types.ExprString(arg)
+		} else {
+			route.Middlewares = append(route.Middlewares, types.ExprString(arg))
+		}
+	}
+	return route
+}
+
+// groupMounts computes the mounts of the group. visiting guards against
+// recursive router functions.
+func (m *ModuleRoutes) groupMounts(g *RouterGroup, visiting map[*RouterFunc]bool) []Mount {
+	switch {
+	case g.Root:
+		return []Mount{{Path: "/"}}
+	case g.Parent != nil:
+		var mounts []Mount
+		for _, mount := range m.groupMounts(g.Parent, visiting) {
+			mounts = appendMount(mounts, Mount{Path: joinPath(mount.Path, g.Path), Middlewares: mount.Middlewares})
+		}
+		return mounts
+	}
+
+	if visiting[g.fn] {
+		return nil
+	}
+	visiting[g.fn] = true
+	defer delete(visiting, g.fn)
+
+	var mounts []Mount
+	for _, call := range g.fn.callers {
+		if call.param != g.Param {
+			continue
+		}
+		for _, mount := range m.groupMounts(call.group, visiting) {
+			middlewares := append(append([]string{}, mount.Middlewares...), call.middlewares...)
+			mounts = appendMount(mounts, Mount{Path: mount.Path, Middlewares: middlewares})
+		}
+	}
+	if len(mounts) == 0 {
+		mounts = []Mount{{Path: "/"}}
+	}
+	return mounts
+}
+
+func appendMount(mounts []Mount, mount Mount) []Mount {
+	for _, v := range mounts {
+		if v.Path == mount.Path && strings.Join(v.Middlewares, ",") == strings.Join(mount.Middlewares, ",") {
+			return mounts
+		}
+	}
+	return append(mounts, mount)
+}
+
+// isGinRouter reports whether t is a gin engine, router group or router interface.
+func isGinRouter(t types.Type) bool {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil || named.Obj().Pkg().Path() != ginPkg {
+		return false
+	}
+	switch named.Obj().Name() {
+	case "Engine", "RouterGroup", "IRouter", "IRoutes":
+		return true
+	}
+	return false
+}
+
+// stringValue returns the value of a constant string expression.
+func stringValue(info *types.Info, expr ast.Expr) string {
+	value, _ := constString(info, expr)
+	return value
+}
+
+// constString returns the value of the expression if it is a constant string.
+func constString(info *types.Info, expr ast.Expr) (string, bool) {
+	if tv, ok := info.Types[expr]; ok && tv.Value != nil && tv.Value.Kind() == constant.String {
+		return constant.StringVal(tv.Value), true
+	}
+	return "", false
+}
+
+// routerMount returns the absolute mount path of the router group received
+// by the router function of cfg, following the calls across the module that
+// pass router groups into it.
+func routerMount(cfg Config) (string, error) {
+	m, err := AnalyzeModule(filepath.Dir(cfg.Router.File))
+	if err != nil {
+		return "", err
+	}
+	fn, err := m.routerFunc(cfg)
+	if err != nil {
+		return "", err
+	}
+	return fn.mountPath(), nil
+}
+
+// apiPath returns the full path of the api under the mount path of the
+// router function. An @group missing from the router function is used as
+// the group path.
+func apiPath(cfg Config, mount string, api API) string {
+	group, err := getGroupPath(cfg.Router.File, cfg.Router.GroupFunc, api.Group)
+	if err != nil {
+		logrus.Warningf("api %s: %v", api.Path, err)
+		group = api.Group
+	}
+	return joinPath(mount, group, api.Path)
+}
+
+// routerFunc returns the router function of cfg.
+func (m *ModuleRoutes) routerFunc(cfg Config) (*RouterFunc, error) {
+	pkg, err := pkgPath(cfg.Router.File)
+	if err != nil {
+		return nil, err
+	}
+	fn := m.Func(pkg + "." + cfg.Router.GroupFunc)
+	if fn == nil {
+		return nil, errors.Errorf("failed to find router func %s", cfg.Router.GroupFunc)
+	}
+	return fn, nil
+}
+
+// mountPath returns the mount path of the router group received by the
+// function as its first parameter. When the function is mounted at several
+// places the first one is used.
+func (fn *RouterFunc) mountPath() string {
+	mounts := fn.ParamMounts(0)
+	if len(mounts) == 0 {
+		return "/"
+	}
+	if len(mounts) > 1 {
+		var paths []string
+		for _, mount := range mounts {
+			paths = append(paths, mount.Path)
+		}
+		logrus.Warningf("%s is mounted at %s, using %s", fn.Name, strings.Join(paths, ", "), paths[0])
+	}
+	return mounts[0].Path
+}
+
+// trimBasePath removes the base path from the beginning of p. A trailing
+// slash of the base path, like /api/v1/, is ignored.
+func trimBasePath(p, basePath string) string {
+	p, basePath = joinPath(p), strings.TrimSuffix(joinPath(basePath), "/")
+	if basePath == "" {
+		return p
+	}
+	if p == basePath {
+		return "/"
+	}
+	if strings.HasPrefix(p, basePath+"/") {
+		return strings.TrimPrefix(p, basePath)
+	}
+	return p
+}
+
+// ListRoutes statically analyzes the module of the router file and returns
+// its routes. Unless all is set, only the routes registered by the router
+// function of cfg are returned.
+func ListRoutes(cfg Config, all bool) ([]Route, error) {
+	m, err := AnalyzeModule(filepath.Dir(cfg.Router.File))
+	if err != nil {
+		return nil, err
+	}
+	if all {
+		return m.Routes(), nil
+	}
+
+	fn, err := m.routerFunc(cfg)
+	if err != nil {
+		return nil, err
+	}
+	routes := fn.Routes()
+	sortRoutes(routes)
+	return routes, nil
+}
+
+// relPath returns filename relative to the working directory when it is
+// inside of it.
+func relPath(filename string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return filename
+	}
+	if rel, err := filepath.Rel(wd, filename); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return filename
+}
@@ -2,15 +2,26 @@ package gen
 
 import (
 	"fmt"
+	"go/token"
+	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/dave/dst"
+	"github.com/pkg/errors"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
 )
 
 type RouteNode struct {
-	Caller   string
-	Path     string
-	Children []*RouteNode
+	Caller   string       `json:"caller"`
+	Path     string       `json:"path"`
+	Children []*RouteNode `json:"children,omitempty"`
+	Routes   []*Route     `json:"routes,omitempty"`
+
+	scope  *dst.BlockStmt  // block containing the group assignment, or the function body for the root
+	assign *dst.AssignStmt // x := parent.Group("path"), nil for the root
+	main   bool            // the root group is created inside main
 }
 
 func BuildRouteTree(routerFile, routerFunc string) (*RouteNode, error) {
@@ -19,57 +30,392 @@ func BuildRouteTree(routerFile, routerFunc string) (*RouteNode, error) {
 		return nil, err
 	}
 
-	root := &RouteNode{Caller: findRootRG(targetFunc), Path: "", Children: []*RouteNode{}}
+	return buildRouteTree(targetFunc), nil
+}
+
+func buildRouteTree(targetFunc *dst.FuncDecl) *RouteNode {
+	root := &RouteNode{
+		Caller:   findRootRG(targetFunc),
+		Path:     "",
+		Children: []*RouteNode{},
+		scope:    targetFunc.Body,
+		main:     targetFunc.Name.Name == "main",
+	}
 
-	parseFunction(targetFunc.Body.List, root)
+	parseFunction(targetFunc.Body, 0, root)
 
-	return root, nil
+	return root
 }
 
-func parseFunction(body []dst.Stmt, parent *RouteNode) {
-	for i, stmt := range body {
-		switch s := stmt.(type) {
+func parseFunction(block *dst.BlockStmt, start int, parent *RouteNode) {
+	for i := start; i < len(block.List); i++ {
+		switch s := block.List[i].(type) {
 		case *dst.AssignStmt:
 			if len(s.Rhs) > 0 {
 				if call, ok := s.Rhs[0].(*dst.CallExpr); ok && isSelectorExpr(call.Fun, "Group") && isTargetRG(call.Fun, parent.Caller) {
 					if len(s.Lhs) > 0 {
 						if lhs, ok := s.Lhs[0].(*dst.Ident); ok {
-							funcNode := &RouteNode{Caller: lhs.Name, Path: getGroupName(call), Children: []*RouteNode{}}
-							parseFunction(body[i+1:], funcNode)
+							funcNode := &RouteNode{Caller: lhs.Name, Path: getGroupName(call), Children: []*RouteNode{}, scope: block, assign: s}
+							parseFunction(block, i+1, funcNode)
 							parent.Children = append(parent.Children, funcNode)
 						}
 					}
 				}
 			}
+		case *dst.ExprStmt:
+			if route := parseRoute(s, parent.Caller); route != nil {
+				parent.Routes = append(parent.Routes, route)
+			}
 		case *dst.BlockStmt:
-			parseFunction(s.List, parent)
+			parseFunction(s, 0, parent)
+		}
+	}
+}
+
+// parseRoute parses a route registration on the router group named caller,
+// like caller.POST("/login", middleware.Jwt(), handler.Login). The route path
+// is relative to the group.
+func parseRoute(stmt *dst.ExprStmt, caller string) *Route {
+	call, ok := stmt.X.(*dst.CallExpr)
+	if !ok || !isTargetRG(call.Fun, caller) {
+		return nil
+	}
+	method, ok := routeMethods[call.Fun.(*dst.SelectorExpr).Sel.Name]
+	if !ok {
+		return nil
+	}
+
+	args := call.Args
+	if method == "" {
+		if len(args) < 1 {
+			return nil
 		}
+		method, args = strings.ToUpper(strings.Trim(dstExprString(args[0]), `"`)), args[1:]
 	}
+	if len(args) < 1 {
+		return nil
+	}
+
+	route := &Route{Method: method, Path: strings.Trim(dstExprString(args[0]), `"`)}
+	handlers := args[1:]
+	for i, arg := range handlers {
+		if i == len(handlers)-1 {
+			route.Handler = dstExprString(arg)
+		} else {
+			route.Middlewares = append(route.Middlewares, dstExprString(arg))
+		}
+	}
+	return route
+}
+
+// dstExprString returns the source of simple expressions used in route
+// registrations: identifiers, selectors, literals and calls.
+func dstExprString(expr dst.Expr) string {
+	switch e := expr.(type) {
+	case *dst.Ident:
+		return e.Name
+	case *dst.BasicLit:
+		return e.Value
+	case *dst.SelectorExpr:
+		return dstExprString(e.X) + "." + e.Sel.Name
+	case *dst.StarExpr:
+		return "*" + dstExprString(e.X)
+	case *dst.UnaryExpr:
+		return e.Op.String() + dstExprString(e.X)
+	case *dst.ParenExpr:
+		return "(" + dstExprString(e.X) + ")"
+	case *dst.CallExpr:
+		args := make([]string, 0, len(e.Args))
+		for _, arg := range e.Args {
+			args = append(args, dstExprString(arg))
+		}
+		return dstExprString(e.Fun) + "(" + strings.Join(args, ", ") + ")"
+	}
+	return "?"
 }
 
 func isTargetRG(expr dst.Expr, rgName string) bool {
-	return expr.(*dst.SelectorExpr).X.(*dst.Ident).Name == rgName
+	if sel, ok := expr.(*dst.SelectorExpr); ok {
+		if ident, ok := sel.X.(*dst.Ident); ok {
+			return ident.Name == rgName
+		}
+	}
+	return false
+}
+
+// splitPath splits a group or route path into its non-empty segments.
+func splitPath(p string) []string {
+	var segs []string
+	for _, seg := range strings.Split(p, "/") {
+		if seg != "" {
+			segs = append(segs, seg)
+		}
+	}
+	return segs
+}
+
+// joinPath joins group and route paths into a single absolute path. Like
+// gin, a trailing slash of the last non-empty path is kept.
+func joinPath(paths ...string) string {
+	var segs []string
+	trailingSlash := false
+	for _, p := range paths {
+		segs = append(segs, splitPath(p)...)
+		if p != "" {
+			trailingSlash = strings.HasSuffix(p, "/")
+		}
+	}
+	if len(segs) == 0 {
+		return "/"
+	}
+	if trailingSlash {
+		return "/" + strings.Join(segs, "/") + "/"
+	}
+	return "/" + strings.Join(segs, "/")
+}
+
+// matchChild returns the child of node whose path is a prefix of segs,
+// and the number of segments it consumes.
+func matchChild(node *RouteNode, segs []string) (*RouteNode, int) {
+	for _, child := range node.Children {
+		childSegs := splitPath(child.Path)
+		if len(childSegs) == 0 || len(childSegs) > len(segs) {
+			continue
+		}
+		if strings.Join(childSegs, "/") == strings.Join(segs[:len(childSegs)], "/") {
+			return child, len(childSegs)
+		}
+	}
+	return nil, 0
+}
+
+// walkGroup follows segs down from node as far as existing groups allow. It
+// returns the deepest group found and the segments without a group.
+func walkGroup(node *RouteNode, segs []string) (*RouteNode, []string) {
+	for len(segs) > 0 {
+		child, n := matchChild(node, segs)
+		if child == nil {
+			break
+		}
+		node, segs = child, segs[n:]
+	}
+	return node, segs
+}
+
+// resolveGroup finds the group of the tree that the slash-separated group
+// path refers to. The path is matched from the root first. When its first
+// segment is not a direct child of the root, the path is matched against the
+// end of every group path in the tree, and then its first segment against
+// every group name; more than one candidate is reported as an error instead
+// of picking one. Segments that have no group yet are returned as missing so
+// that they can be created.
+func resolveGroup(root *RouteNode, group string) (node *RouteNode, path []string, missing []string, err error) {
+	segs := splitPath(group)
+	if len(segs) == 0 {
+		return root, nil, nil, nil
+	}
+
+	node, missing = walkGroup(root, segs)
+	if node != root {
+		return node, nodePath(root, node), missing, nil
+	}
+
+	candidates := findGroups(root, segs)
+	rest := []string{}
+	if len(candidates) == 0 {
+		candidates = findGroups(root, segs[:1])
+		rest = segs[1:]
+	}
+	switch len(candidates) {
+	case 0:
+		return root, nil, segs, nil
+	case 1:
+		node, missing = walkGroup(candidates[0][len(candidates[0])-1], rest)
+		return node, nodePath(root, node), missing, nil
+	}
+
+	paths := make([]string, 0, len(candidates))
+	for _, chain := range candidates {
+		paths = append(paths, joinPath(nodesPath(chain)...))
+	}
+	return nil, nil, nil, errors.Errorf("group %q is ambiguous, use the full path of one of: %s", group, strings.Join(paths, ", "))
+}
+
+// findGroups returns the chains of nodes from the root to every group whose
+// path ends with segs.
+func findGroups(root *RouteNode, segs []string) [][]*RouteNode {
+	suffix := strings.Join(segs, "/")
+
+	var result [][]*RouteNode
+	var dfs func(node *RouteNode, chain []*RouteNode)
+	dfs = func(node *RouteNode, chain []*RouteNode) {
+		chain = append(chain, node)
+		if node != root {
+			if p := strings.Join(nodesPath(chain), "/"); p == suffix || strings.HasSuffix(p, "/"+suffix) {
+				result = append(result, append([]*RouteNode{}, chain...))
+			}
+		}
+		for _, child := range node.Children {
+			dfs(child, chain)
+		}
+	}
+	dfs(root, nil)
+	return result
+}
+
+// nodePath returns the path segments from root to target.
+func nodePath(root, target *RouteNode) []string {
+	var chain []*RouteNode
+	var dfs func(node *RouteNode) bool
+	dfs = func(node *RouteNode) bool {
+		chain = append(chain, node)
+		if node == target {
+			return true
+		}
+		for _, child := range node.Children {
+			if dfs(child) {
+				return true
+			}
+		}
+		chain = chain[:len(chain)-1]
+		return false
+	}
+	dfs(root)
+	return nodesPath(chain)
 }
 
-func getGroupPath(routerFile, routerFunc, group string) string {
+func nodesPath(chain []*RouteNode) []string {
+	var segs []string
+	for _, node := range chain {
+		segs = append(segs, splitPath(node.Path)...)
+	}
+	return segs
+}
+
+// body returns the block where statements of the group are registered and the
+// index at which new statements are inserted.
+func (n *RouteNode) body() (*dst.BlockStmt, int) {
+	if n.assign == nil {
+		index := len(n.scope.List)
+		if n.main && index > 0 {
+			index--
+		}
+		return n.scope, index
+	}
+
+	for i, stmt := range n.scope.List {
+		if stmt != n.assign {
+			continue
+		}
+		if i+1 < len(n.scope.List) {
+			if block, ok := n.scope.List[i+1].(*dst.BlockStmt); ok {
+				return block, len(block.List)
+			}
+		}
+		// no block follows the group, insert after the statements using it
+		j := i + 1
+		for ; j < len(n.scope.List); j++ {
+			expr, ok := n.scope.List[j].(*dst.ExprStmt)
+			if !ok {
+				break
+			}
+			if call, ok := expr.X.(*dst.CallExpr); !ok || !isTargetRG(call.Fun, n.Caller) {
+				break
+			}
+		}
+		return n.scope, j
+	}
+	return n.scope, len(n.scope.List)
+}
+
+// insert inserts the statements into the body of the group.
+func (n *RouteNode) insert(stmts ...dst.Stmt) {
+	block, index := n.body()
+	block.List = append(block.List[:index], append(stmts, block.List[index:]...)...)
+}
+
+// addGroup creates `x := n.Group("path")` followed by an empty block in the
+// body of n and returns the new group.
+func (n *RouteNode) addGroup(path, varName string) *RouteNode {
+	assign := &dst.AssignStmt{
+		Lhs: []dst.Expr{dst.NewIdent(varName)},
+		Tok: token.DEFINE,
+		Rhs: []dst.Expr{&dst.CallExpr{
+			Fun:  &dst.SelectorExpr{X: dst.NewIdent(n.Caller), Sel: dst.NewIdent("Group")},
+			Args: []dst.Expr{&dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(path)}},
+		}},
+	}
+	block := &dst.BlockStmt{}
+	assign.Decs.Before = dst.NewLine
+	block.Decs.After = dst.NewLine
+	n.insert(assign, block)
+
+	scope, _ := n.body()
+	child := &RouteNode{Caller: varName, Path: path, Children: []*RouteNode{}, scope: scope, assign: assign}
+	n.Children = append(n.Children, child)
+	return child
+}
+
+// groupVarName derives an unused variable name for a new group.
+func groupVarName(fn *dst.FuncDecl, path string) string {
+	used := map[string]bool{}
+	dst.Inspect(fn, func(node dst.Node) bool {
+		if ident, ok := node.(*dst.Ident); ok {
+			used[ident.Name] = true
+		}
+		return true
+	})
+
+	var sb strings.Builder
+	upper := false
+	for _, r := range path {
+		switch {
+		case unicode.IsLetter(r) || (unicode.IsDigit(r) && sb.Len() > 0):
+			if upper {
+				r = unicode.ToUpper(r)
+			}
+			sb.WriteRune(r)
+			upper = false
+		default:
+			upper = sb.Len() > 0
+		}
+	}
+	name := sb.String()
+	if name == "" || token.IsKeyword(name) {
+		name = "group" + cases.Title(language.English).String(name)
+	}
+
+	varName := name
+	for i := 2; used[varName]; i++ {
+		varName = name + strconv.Itoa(i)
+	}
+	return varName
+}
+
+func getGroupPath(routerFile, routerFunc, group string) (string, error) {
 
 	tree, err := BuildRouteTree(routerFile, routerFunc)
 	if err != nil {
-		fmt.Println("Failed to build route tree:", err)
-		return ""
+		return "", errors.Wrap(err, "failed to build route tree")
 	}
 
 	// printRouteTree(tree, 0)
-	path := DFSPath(tree, group)
-	if len(path) < 1 {
-		return ""
+	_, path, missing, err := resolveGroup(tree, group)
+	if err != nil {
+		return "", err
+	}
+	if len(path)+len(missing) == 0 {
+		return "", nil
 	}
-	return strings.Join(path[0], "/")
+	return joinPath(append(path, missing...)...), nil
 }
 
 func printRouteTree(node *RouteNode, depth int) {
 	indent := strings.Repeat("  ", depth)
 	fmt.Printf("%s%s\n", indent, node.Caller)
+	for _, route := range node.Routes {
+		fmt.Printf("%s  %s %s %s\n", indent, route.Method, route.Path, route.Handler)
+	}
 
 	for _, child := range node.Children {
 		printRouteTree(child, depth+1)
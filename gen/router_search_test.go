@@ -0,0 +1,107 @@
+package gen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dave/dst/decorator"
+)
+
+var resolveGroupRouter = `package router
+
+func UserRouter(rg *gin.RouterGroup) {
+	api := rg.Group("api")
+	{
+		v1 := api.Group("v1")
+		{
+			user := v1.Group("user")
+			user.GET("/info", nil)
+		}
+		v2 := api.Group("v2")
+		{
+			user := v2.Group("user")
+			user.GET("/info", nil)
+			admin := v2.Group("admin/system")
+			admin.GET("/status", nil)
+		}
+	}
+	public := rg.Group("public")
+	public.GET("/ping", nil)
+}
+`
+
+func TestResolveGroup(t *testing.T) {
+	file, err := decorator.Parse(resolveGroupRouter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn, err := findFunc(file, "UserRouter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := buildRouteTree(fn)
+
+	tests := []struct {
+		group   string
+		path    string // path of the group found
+		missing []string
+		err     string // part of the error for ambiguous groups
+	}{
+		{group: "", path: "/"},
+		{group: "api/v1/user", path: "/api/v1/user"},
+		{group: "/api/v2/", path: "/api/v2"},
+		{group: "public", path: "/public"},
+		{group: "api/v3/user", path: "/api", missing: []string{"v3", "user"}},
+		{group: "v1", path: "/api/v1"},
+		{group: "v1/user", path: "/api/v1/user"},
+		{group: "v2/user/profile", path: "/api/v2/user", missing: []string{"profile"}},
+		{group: "system", path: "/api/v2/admin/system"},
+		{group: "admin/system", path: "/api/v2/admin/system"},
+		{group: "v1/order", path: "/api/v1", missing: []string{"order"}},
+		{group: "order/item", path: "/", missing: []string{"order", "item"}},
+		{group: "user", err: `group "user" is ambiguous, use the full path of one of: /api/v1/user, /api/v2/user`},
+		{group: "user/info", err: "/api/v1/user, /api/v2/user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.group, func(t *testing.T) {
+			node, path, missing, err := resolveGroup(root, tt.group)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("resolveGroup() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveGroup() error = %v", err)
+			}
+			if got := joinPath(path...); got != tt.path {
+				t.Errorf("path = %s, want %s", got, tt.path)
+			}
+			if got := joinPath(nodePath(root, node)...); got != tt.path {
+				t.Errorf("node = %s, want %s", got, tt.path)
+			}
+			if strings.Join(missing, "/") != strings.Join(tt.missing, "/") {
+				t.Errorf("missing = %v, want %v", missing, tt.missing)
+			}
+		})
+	}
+}
+
+func TestTrimBasePath(t *testing.T) {
+	tests := []struct {
+		path, basePath, want string
+	}{
+		{"/api/v1/user", "/api/v1", "/user"},
+		{"/api/v1/user", "/api/v1/", "/user"},
+		{"/api/v1/user", "api/v1", "/user"},
+		{"/api/v1", "/api/v1/", "/"},
+		{"/api/v10/user", "/api/v1", "/api/v10/user"},
+		{"/user", "", "/user"},
+		{"/user", "/", "/user"},
+	}
+	for _, tt := range tests {
+		if got := trimBasePath(tt.path, tt.basePath); got != tt.want {
+			t.Errorf("trimBasePath(%q, %q) = %q, want %q", tt.path, tt.basePath, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package gen
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/pkg/errors"
+)
+
+// Tx stages the changes of one generation in memory. Every step reads the
+// files through the transaction, so it sees the changes of the previous
+// steps, and nothing touches the disk until Commit writes all the files.
+type Tx struct {
+	staged    map[string][]byte // filename -> new content
+	order     []string          // filenames in the order they were staged
+	originals map[string][]byte // filename -> content before the commit
+}
+
+// renameFile moves the temp files into place. Tests replace it to fail.
+var renameFile = os.Rename
+
+func NewTx() *Tx {
+	return &Tx{staged: map[string][]byte{}, originals: map[string][]byte{}}
+}
+
+// ReadFile returns the staged content of the file, or its content on disk.
+func (tx *Tx) ReadFile(filename string) ([]byte, error) {
+	if src, ok := tx.staged[filepath.Clean(filename)]; ok {
+		return src, nil
+	}
+	return os.ReadFile(filename)
+}
+
+// ParseFile parses the staged content of the Go file.
+func (tx *Tx) ParseFile(filename string) (*dst.File, error) {
+	src, err := tx.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return decorator.ParseFile(token.NewFileSet(), filename, src, parser.ParseComments)
+}
+
+// Write renders the file and stages it. A file that fails to render is not
+// staged, so the previous content stays in place.
+func (tx *Tx) Write(filename string, file *dst.File) error {
+	var buf bytes.Buffer
+	if err := decorator.Fprint(&buf, file); err != nil {
+		return errors.Wrapf(err, "failed to render %s", filename)
+	}
+	tx.WriteFile(filename, buf.Bytes())
+	return nil
+}
+
+// WriteFile stages the content of the file, which is created on commit if
+// it does not exist.
+func (tx *Tx) WriteFile(filename string, src []byte) {
+	filename = filepath.Clean(filename)
+	if _, ok := tx.staged[filename]; !ok {
+		tx.order = append(tx.order, filename)
+	}
+	tx.staged[filename] = src
+}
+
+// Files returns the staged filenames in the order they were first written.
+func (tx *Tx) Files() []string {
+	return append([]string(nil), tx.order...)
+}
+
+// Rollback discards the staged changes.
+func (tx *Tx) Rollback() {
+	tx.staged, tx.order = map[string][]byte{}, nil
+}
+
+// Commit writes every staged file to a temp file next to it and renames the
+// temp files into place once all of them are written. If a rename fails, the
+// files already renamed are restored, so either all files change or none.
+func (tx *Tx) Commit() error {
+	defer tx.Rollback()
+
+	tx.originals = map[string][]byte{}
+	temps := make([]string, 0, len(tx.order))
+	defer func() {
+		for _, temp := range temps {
+			os.Remove(temp)
+		}
+	}()
+	for _, filename := range tx.order {
+		src, err := os.ReadFile(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		tx.originals[filename] = src
+
+		temp, err := writeTemp(filename, tx.staged[filename])
+		if err != nil {
+			return err
+		}
+		temps = append(temps, temp)
+	}
+
+	for i, filename := range tx.order {
+		if err := renameFile(temps[i], filename); err != nil {
+			// 回滚已经替换的文件
+			for _, done := range tx.order[:i] {
+				if rerr := tx.restore(done); rerr != nil {
+					err = errors.Wrapf(err, "failed to restore %s: %v", done, rerr)
+				}
+			}
+			return errors.Wrapf(err, "failed to write %s", filename)
+		}
+	}
+	temps = nil
+	return nil
+}
+
+// Revert restores the files changed by the last commit to their previous
+// content.
+func (tx *Tx) Revert() error {
+	for filename := range tx.originals {
+		if err := tx.restore(filename); err != nil {
+			return err
+		}
+	}
+	tx.originals = map[string][]byte{}
+	return nil
+}
+
+func (tx *Tx) restore(filename string) error {
+	src := tx.originals[filename]
+	if src == nil {
+		return os.Remove(filename)
+	}
+	temp, err := writeTemp(filename, src)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(temp, filename); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+// writeTemp writes the content to a synced temp file in the directory of
+// filename, with the permissions of filename.
+func writeTemp(filename string, src []byte) (string, error) {
+	mode := os.FileMode(0o644)
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(src)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), mode)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", errors.Wrapf(err, "failed to write %s", filename)
+	}
+	return f.Name(), nil
+}
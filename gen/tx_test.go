@@ -0,0 +1,176 @@
+package gen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes the files into dir. Names ending in / are directories.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkFiles checks the content of the files of dir, "" for missing files,
+// and that no temp file is left behind.
+func checkFiles(t *testing.T, dir string, want map[string]string) {
+	t.Helper()
+	for name, src := range want {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		switch {
+		case src == "" && !os.IsNotExist(err):
+			t.Errorf("%s exists, want it missing", name)
+		case src != "" && err != nil:
+			t.Errorf("failed to read %s: %v", name, err)
+		case src != "" && string(got) != src:
+			t.Errorf("%s = %q, want %q", name, got, src)
+		}
+	}
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, ".tmp") {
+			t.Errorf("temp file %s is left behind", path)
+		}
+		return nil
+	})
+}
+
+func TestTxCommit(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      map[string]string // files on disk before the commit
+		staged     [][2]string       // filename and content, in order
+		failRename string            // file whose rename fails
+		wantErr    bool
+		want       map[string]string // files after the commit, "" for missing files
+	}{
+		{
+			name:   "writes every file",
+			files:  map[string]string{"a.go": "a"},
+			staged: [][2]string{{"a.go", "a2"}, {"b/b.go", "b"}},
+			want:   map[string]string{"a.go": "a2", "b/b.go": "b"},
+		},
+		{
+			name:   "last write of a file wins",
+			files:  map[string]string{"a.go": "a"},
+			staged: [][2]string{{"a.go", "a2"}, {"./a.go", "a3"}},
+			want:   map[string]string{"a.go": "a3"},
+		},
+		{
+			name:       "failing rename restores the files already renamed",
+			files:      map[string]string{"a.go": "a", "b.go": "b", "d.go": "d"},
+			staged:     [][2]string{{"a.go", "a2"}, {"c/c.go", "c"}, {"d.go", "d2"}, {"b.go", "b2"}},
+			failRename: "d.go",
+			wantErr:    true,
+			want:       map[string]string{"a.go": "a", "b.go": "b", "c/c.go": "", "d.go": "d"},
+		},
+		{
+			name:       "failing first rename changes nothing",
+			files:      map[string]string{"a.go": "a"},
+			staged:     [][2]string{{"a.go", "a2"}, {"b.go", "b"}},
+			failRename: "a.go",
+			wantErr:    true,
+			want:       map[string]string{"a.go": "a", "b.go": ""},
+		},
+		{
+			name:    "unreadable original changes nothing",
+			files:   map[string]string{"a.go": "a", "dir/": ""},
+			staged:  [][2]string{{"a.go", "a2"}, {"dir", "d"}},
+			wantErr: true,
+			want:    map[string]string{"a.go": "a"},
+		},
+		{
+			name:    "failing temp write changes nothing",
+			files:   map[string]string{"a.go": "a"},
+			staged:  [][2]string{{"a.go", "a2"}, {"c.go", "c"}, {"a.go/x.go", "x"}},
+			wantErr: true,
+			want:    map[string]string{"a.go": "a", "c.go": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			if tt.failRename != "" {
+				defer func() { renameFile = os.Rename }()
+				renameFile = func(oldpath, newpath string) error {
+					if newpath == filepath.Join(dir, tt.failRename) {
+						return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrPermission}
+					}
+					return os.Rename(oldpath, newpath)
+				}
+			}
+
+			tx := NewTx()
+			for _, f := range tt.staged {
+				tx.WriteFile(filepath.Join(dir, f[0]), []byte(f[1]))
+			}
+			if err := tx.Commit(); (err != nil) != tt.wantErr {
+				t.Fatalf("Commit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			checkFiles(t, dir, tt.want)
+			if files := tx.Files(); len(files) != 0 {
+				t.Errorf("Files() = %v after the commit, want none", files)
+			}
+		})
+	}
+}
+
+func TestTxRevert(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.go": "a", "b.go": "b"})
+
+	tx := NewTx()
+	tx.WriteFile(filepath.Join(dir, "a.go"), []byte("a2"))
+	tx.WriteFile(filepath.Join(dir, "c/c.go"), []byte("c"))
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, dir, map[string]string{"a.go": "a2", "b.go": "b", "c/c.go": "c"})
+
+	if err := tx.Revert(); err != nil {
+		t.Fatalf("Revert() error = %v", err)
+	}
+	checkFiles(t, dir, map[string]string{"a.go": "a", "b.go": "b", "c/c.go": ""})
+
+	// 再次撤销没有可恢复的文件
+	if err := tx.Revert(); err != nil {
+		t.Fatalf("second Revert() error = %v", err)
+	}
+	checkFiles(t, dir, map[string]string{"a.go": "a", "b.go": "b"})
+}
+
+func TestTxRollback(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.go": "a"})
+	filename := filepath.Join(dir, "a.go")
+
+	tx := NewTx()
+	tx.WriteFile(filename, []byte("a2"))
+	if src, err := tx.ReadFile(filename); err != nil || string(src) != "a2" {
+		t.Fatalf("ReadFile() = %q, %v, want the staged content", src, err)
+	}
+	tx.Rollback()
+	if src, err := tx.ReadFile(filename); err != nil || string(src) != "a" {
+		t.Fatalf("ReadFile() = %q, %v after Rollback, want the content on disk", src, err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, dir, map[string]string{"a.go": "a"})
+}
@@ -0,0 +1,375 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// 校验规则的提示信息，参数依次为字段名、规则参数和规则名
+var validateRuleMessages = map[string]map[string]string{
+	"en": {
+		"required":   "%[1]s is required",
+		"max.string": "%[1]s must be at most %[2]s chars",
+		"max.number": "%[1]s must be %[2]s or less",
+		"max.list":   "%[1]s must contain at most %[2]s items",
+		"min.string": "%[1]s must be at least %[2]s chars",
+		"min.number": "%[1]s must be %[2]s or greater",
+		"min.list":   "%[1]s must contain at least %[2]s items",
+		"len.string": "%[1]s must be %[2]s chars long",
+		"len.number": "%[1]s must equal %[2]s",
+		"len.list":   "%[1]s must contain %[2]s items",
+		"eq":         "%[1]s must equal %[2]s",
+		"ne":         "%[1]s must not equal %[2]s",
+		"gt":         "%[1]s must be greater than %[2]s",
+		"gte":        "%[1]s must be %[2]s or greater",
+		"lt":         "%[1]s must be less than %[2]s",
+		"lte":        "%[1]s must be %[2]s or less",
+		"oneof":      "%[1]s must be one of [%[2]s]",
+		"email":      "%[1]s must be a valid email",
+		"url":        "%[1]s must be a valid URL",
+		"uuid":       "%[1]s must be a valid UUID",
+		"numeric":    "%[1]s must be numeric",
+		"alphanum":   "%[1]s must be alphanumeric",
+		"":           "%[1]s failed the %[3]s rule",
+	},
+	"zh": {
+		"required":   "%[1]s为必填项",
+		"max.string": "%[1]s长度不能超过%[2]s个字符",
+		"max.number": "%[1]s不能大于%[2]s",
+		"max.list":   "%[1]s最多包含%[2]s项",
+		"min.string": "%[1]s长度不能少于%[2]s个字符",
+		"min.number": "%[1]s不能小于%[2]s",
+		"min.list":   "%[1]s至少包含%[2]s项",
+		"len.string": "%[1]s长度必须为%[2]s个字符",
+		"len.number": "%[1]s必须等于%[2]s",
+		"len.list":   "%[1]s必须包含%[2]s项",
+		"eq":         "%[1]s必须等于%[2]s",
+		"ne":         "%[1]s不能等于%[2]s",
+		"gt":         "%[1]s必须大于%[2]s",
+		"gte":        "%[1]s不能小于%[2]s",
+		"lt":         "%[1]s必须小于%[2]s",
+		"lte":        "%[1]s不能大于%[2]s",
+		"oneof":      "%[1]s必须是[%[2]s]中的一个",
+		"email":      "%[1]s必须是有效的邮箱地址",
+		"url":        "%[1]s必须是有效的URL",
+		"uuid":       "%[1]s必须是有效的UUID",
+		"numeric":    "%[1]s必须是数字",
+		"alphanum":   "%[1]s只能包含字母和数字",
+		"":           "%[1]s未通过%[3]s校验",
+	},
+}
+
+// 不产生校验错误的规则
+var validateSkipRules = map[string]bool{
+	"omitempty": true, "dive": true, "keys": true, "endkeys": true,
+	"structonly": true, "nostructlevel": true, "-": true,
+}
+
+var validateTmp = `// Code generated by api-gen. DO NOT EDIT.
+
+package {{ .Pkg }}
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// validateMessages holds the messages of the binding rules of the request
+// structs by language, field namespace and rule.
+var validateMessages = map[string]map[string]map[string]string{
+{{ .Messages }}
+}
+
+// validateFallback holds the messages of errors not found in validateMessages.
+var validateFallback = map[string]struct{ typ, rule, sep string }{
+	"en": {"%s must be of type %s", "%s failed the %s rule", "; "},
+	"zh": {"请求参数` + "`%s`" + `类型错误，应为%s类型", "参数` + "`%s`" + `未通过%s校验", "；"},
+}
+
+var validateIndex = regexp.MustCompile(` + "`\\[[^\\]]*\\]`" + `)
+
+// ValidateErrMsg translates the error of binding a request into the language
+// preferred by the Accept-Language header, {{ .Default }} by default.
+func ValidateErrMsg(c *gin.Context, err error) string {
+	lang := validateLang(c.GetHeader("Accept-Language"))
+	switch v := err.(type) {
+	case *json.UnmarshalTypeError:
+		return fmt.Sprintf(validateFallback[lang].typ, v.Field, v.Type.Name())
+	case validator.ValidationErrors:
+		msgs := make([]string, 0, len(v))
+		for _, e := range v {
+			// 切片和 map 元素的下标统一为 []
+			ns := validateIndex.ReplaceAllString(e.StructNamespace(), "[]")
+			if msg, ok := validateMessages[lang][ns][e.Tag()]; ok {
+				msgs = append(msgs, msg)
+			} else {
+				msgs = append(msgs, fmt.Sprintf(validateFallback[lang].rule, strings.ToLower(e.Field()), e.Tag()))
+			}
+		}
+		return strings.Join(msgs, validateFallback[lang].sep)
+	}
+	return err.Error()
+}
+
+// validateLang returns the supported language with the highest quality in
+// the Accept-Language header.
+func validateLang(header string) string {
+	type accept struct {
+		lang string
+		q    float64
+	}
+	var accepts []accept
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if _, ok := validateFallback[lang]; !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		accepts = append(accepts, accept{lang, q})
+	}
+	sort.SliceStable(accepts, func(i, j int) bool { return accepts[i].q > accepts[j].q })
+	if len(accepts) == 0 || accepts[0].q <= 0 {
+		return {{ printf "%q" .Default }}
+	}
+	return accepts[0].lang
+}
+`
+
+// validateRule is a binding rule of a field, like max=32.
+type validateRule struct {
+	tag, param, kind string
+	dive             int // number of dives before the rule
+}
+
+// validateField is a field with binding rules, named by its namespace like
+// LoginReq.Name, or LoginReq.Tags[] for the elements of a list.
+type validateField struct {
+	ns, label string
+	rules     []validateRule
+}
+
+// genValidate renders the validation messages of the request structs of the
+// model into the file of the config.
+func genValidate(tx *Tx, cfg Config, m *Model) error {
+	filename := cfg.Validate.File
+	pkg, err := dirPkgName(filename)
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]validateField{}
+	for _, api := range m.APIs {
+		typ := strings.TrimPrefix(api.RequestType, "*")
+		if api.Request == nil || api.Request.Name == "" || strings.HasPrefix(typ, "[") || strings.HasPrefix(typ, "map[") {
+			continue
+		}
+		collectValidateFields(api.Request.Name, api.Request, fields)
+	}
+	keys := make([]string, 0, len(fields))
+	for ns := range fields {
+		keys = append(keys, ns)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, lang := range []string{"en", "zh"} {
+		fmt.Fprintf(&sb, "%q: {\n", lang)
+		for _, ns := range keys {
+			f := fields[ns]
+			fmt.Fprintf(&sb, "%q: {", ns)
+			for i, r := range f.rules {
+				if i > 0 {
+					sb.WriteString(", ")
+				}
+				fmt.Fprintf(&sb, "%q: %q", r.tag, validateMessage(lang, f, r))
+			}
+			sb.WriteString("},\n")
+		}
+		sb.WriteString("},\n")
+	}
+
+	content, err := execTemplate(validateTmp, map[string]string{
+		"Pkg":      pkg,
+		"Messages": sb.String(),
+		"Default":  "zh",
+	})
+	if err != nil {
+		return err
+	}
+	src, err := format.Source([]byte(content))
+	if err != nil {
+		return errors.Wrap(err, "failed to format the validation messages")
+	}
+	if old, err := tx.ReadFile(filename); err == nil && bytes.Equal(old, src) {
+		return nil
+	}
+	tx.WriteFile(filename, src)
+	fmt.Println("File", filename, "will be written.")
+	return nil
+}
+
+// collectValidateFields adds the fields of the schema with binding rules,
+// and those of its nested structs, to fields. Rules after dive are added
+// under the namespace of the elements.
+func collectValidateFields(ns string, s *Schema, fields map[string]validateField) {
+	for _, f := range s.Fields {
+		fieldNS := ns + "." + f.Name
+		for _, r := range parseValidateRules(f.Tags["binding"], f.Type) {
+			elemNS := fieldNS + strings.Repeat("[]", r.dive)
+			field, ok := fields[elemNS]
+			if !ok {
+				field = validateField{ns: elemNS, label: validateLabel(f)}
+			}
+			field.rules = append(field.rules, r)
+			fields[elemNS] = field
+		}
+		if f.Schema != nil {
+			collectValidateFields(fieldNS+strings.Repeat("[]", listDepth(f.Type)), f.Schema, fields)
+		}
+	}
+}
+
+// listDepth returns the number of slices, arrays and maps around the element
+// type of typ, like 2 for [][]*Item.
+func listDepth(typ string) int {
+	n := 0
+	for t := strings.TrimPrefix(typ, "*"); (strings.HasPrefix(t, "[") || strings.HasPrefix(t, "map[")) && t != "[]byte"; t = strings.TrimPrefix(elemType(t), "*") {
+		n++
+	}
+	return n
+}
+
+// parseValidateRules parses the binding tag of a field of type typ. Rules
+// after dive apply to the elements.
+func parseValidateRules(tag, typ string) []validateRule {
+	var rules []validateRule
+	// 同名规则按 dive 的层级区分，如 max=10,dive,max=8
+	seen := map[string]bool{}
+	depth := 0
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if name == "dive" {
+			typ = elemType(typ)
+			depth++
+		}
+		key := fmt.Sprintf("%s/%d", name, depth)
+		if name == "" || validateSkipRules[name] || seen[key] {
+			continue
+		}
+		seen[key] = true
+		rules = append(rules, validateRule{tag: name, param: param, kind: validateKind(typ), dive: depth})
+	}
+	return rules
+}
+
+// elemType returns the element type of a slice, array or map type.
+func elemType(typ string) string {
+	typ = strings.TrimPrefix(typ, "*")
+	if strings.HasPrefix(typ, "map[") {
+		depth := 0
+		for i, r := range typ {
+			switch r {
+			case '[':
+				depth++
+			case ']':
+				if depth--; depth == 0 {
+					return typ[i+1:]
+				}
+			}
+		}
+	}
+	if i := strings.Index(typ, "]"); strings.HasPrefix(typ, "[") && i > 0 {
+		return typ[i+1:]
+	}
+	return typ
+}
+
+// validateLabel names the field by its comment, or by the name it is bound
+// from.
+func validateLabel(f Field) string {
+	if line, _, _ := strings.Cut(f.Comment, "\n"); line != "" {
+		return line
+	}
+	for _, key := range []string{"form", "json", "uri", "header"} {
+		if name, _, _ := strings.Cut(f.Tags[key], ","); name != "" && name != "-" {
+			return name
+		}
+	}
+	return strings.ToLower(f.Name)
+}
+
+// validateKind classifies the type of a field for the length rules.
+func validateKind(typ string) string {
+	typ = strings.TrimPrefix(typ, "*")
+	switch {
+	case typ == "string":
+		return "string"
+	case strings.HasPrefix(typ, "[") || strings.HasPrefix(typ, "map["):
+		return "list"
+	}
+	return "number"
+}
+
+func validateMessage(lang string, f validateField, r validateRule) string {
+	msgs := validateRuleMessages[lang]
+	format, ok := msgs[r.tag+"."+r.kind]
+	if !ok {
+		format, ok = msgs[r.tag]
+	}
+	if !ok && strings.HasPrefix(r.tag, "required_") {
+		format, ok = msgs["required"]
+	}
+	if !ok {
+		format = msgs[""]
+	}
+	return fmt.Sprintf(format, f.label, r.param, r.tag)
+}
+
+// dirPkgName returns the package name of the Go file, read from the file or
+// the other files of its directory, or the name of the directory.
+func dirPkgName(filename string) (string, error) {
+	if src, err := os.ReadFile(filename); err == nil {
+		file, err := parser.ParseFile(token.NewFileSet(), filename, src, parser.PackageClauseOnly)
+		if err != nil {
+			return "", err
+		}
+		return file.Name.Name, nil
+	}
+	dir := filepath.Dir(filename)
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	for _, match := range matches {
+		if strings.HasSuffix(match, "_test.go") {
+			continue
+		}
+		if file, err := parser.ParseFile(token.NewFileSet(), match, nil, parser.PackageClauseOnly); err == nil {
+			return file.Name.Name, nil
+		}
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(abs), nil
+}
@@ -0,0 +1,163 @@
+package gen
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/imports"
+)
+
+// VerifyError reports the type errors the generated code of an api
+// introduced in the touched packages.
+type VerifyError struct {
+	API      string
+	Errors   []string // file:line:col: message
+	Reverted bool
+}
+
+func (e *VerifyError) Error() string {
+	msg := fmt.Sprintf("generated code of api %s does not compile", e.API)
+	if e.Reverted {
+		msg += ", changes reverted"
+	}
+	return msg + ":\n\t" + strings.Join(e.Errors, "\n\t")
+}
+
+// Format runs goimports on the staged Go files: missing imports are added
+// from the standard library, the module and its dependencies, unused ones
+// are removed, and the imports are sorted and grouped. go.mod and go.sum
+// are left as they are.
+func (tx *Tx) Format() error {
+	// 解析缺失的导入会运行 go list，只读模式避免改写 go.mod 和 go.sum
+	if flags, ok := os.LookupEnv("GOFLAGS"); !strings.Contains(flags, "-mod=") {
+		os.Setenv("GOFLAGS", strings.TrimSpace(flags+" -mod=readonly"))
+		if ok {
+			defer os.Setenv("GOFLAGS", flags)
+		} else {
+			defer os.Unsetenv("GOFLAGS")
+		}
+	}
+	opt := &imports.Options{Comments: true, TabIndent: true, TabWidth: 8}
+	for _, filename := range tx.order {
+		if filepath.Ext(filename) != ".go" {
+			continue
+		}
+		src, err := imports.Process(filename, tx.staged[filename], opt)
+		if err != nil {
+			return errors.Wrapf(err, "failed to format %s", filename)
+		}
+		tx.staged[filename] = src
+	}
+	return nil
+}
+
+// typeErrors type-checks the packages of the files and returns their errors
+// by package directory. Errors are keyed by file, message and occurrence so
+// that they survive line shifts, and every package checked has an entry.
+func typeErrors(files []string) (map[string]map[string]string, error) {
+	root, dirs, err := packageDirs(files)
+	if err != nil || len(dirs) == 0 {
+		return nil, err
+	}
+
+	patterns := make([]string, 0, len(dirs))
+	errs := map[string]map[string]string{}
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, "./"+filepath.ToSlash(rel))
+		errs[dir] = map[string]string{}
+	}
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes,
+		Dir:  root,
+	}, patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pkg := range pkgs {
+		for _, e := range pkg.Errors {
+			// 编译输出的汇总错误没有位置，逐条的类型错误已包含其内容
+			if e.Pos == "" {
+				continue
+			}
+			file := e.Pos
+			if i := strings.Index(file, ":"); i >= 0 {
+				file = file[:i]
+			}
+			dir := filepath.Dir(file)
+			if errs[dir] == nil {
+				errs[dir] = map[string]string{}
+			}
+			pos, file := relPath(e.Pos), relPath(file)
+			for n := 0; ; n++ {
+				key := fmt.Sprintf("%s: %s#%d", file, e.Msg, n)
+				if _, ok := errs[dir][key]; !ok {
+					errs[dir][key] = pos + ": " + e.Msg
+					break
+				}
+			}
+		}
+	}
+	return errs, nil
+}
+
+// packageDirs returns the module root and the absolute directories of the
+// packages of the Go files.
+func packageDirs(files []string) (string, []string, error) {
+	var dirs []string
+	seen := map[string]bool{}
+	for _, file := range files {
+		if filepath.Ext(file) != ".go" {
+			continue
+		}
+		dir, err := filepath.Abs(filepath.Dir(file))
+		if err != nil {
+			return "", nil, err
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return "", nil, nil
+	}
+	root, err := moduleRoot(dirs[0])
+	if err != nil {
+		return "", nil, err
+	}
+	return root, dirs, nil
+}
+
+// verifyFiles type-checks the packages of the files written for the api. It
+// returns the errors of the packages, and a VerifyError with the ones that
+// are not in the baseline of their package.
+func verifyFiles(api string, files []string, baseline map[string]map[string]string) (map[string]map[string]string, error) {
+	errs, err := typeErrors(files)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to type-check the generated code")
+	}
+
+	var found []string
+	for dir, pkgErrs := range errs {
+		for key, e := range pkgErrs {
+			if _, ok := baseline[dir][key]; !ok {
+				found = append(found, e)
+			}
+		}
+	}
+	if len(found) == 0 {
+		return errs, nil
+	}
+	sort.Strings(found)
+	return errs, &VerifyError{API: api, Errors: found}
+}
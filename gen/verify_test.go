@@ -0,0 +1,32 @@
+package gen
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTxFormatAddsImports(t *testing.T) {
+	filename, err := filepath.Abs("../example/logic/format.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := os.ReadFile("../go.sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := NewTx()
+	tx.WriteFile(filename, []byte("package logic\n\nimport \"os\"\n\nfunc format(req types.LoginReq) string {\n\treturn strings.ToUpper(req.Name)\n}\n"))
+	if err := tx.Format(); err != nil {
+		t.Fatal(err)
+	}
+	want := "package logic\n\nimport (\n\t\"strings\"\n\n\t\"github.com/ydssx/api-gen/example/types\"\n)\n\nfunc format(req types.LoginReq) string {\n\treturn strings.ToUpper(req.Name)\n}\n"
+	if got := string(tx.staged[filename]); got != want {
+		t.Errorf("Format() = %q, want %q", got, want)
+	}
+	if after, err := os.ReadFile("../go.sum"); err != nil || !bytes.Equal(after, sum) {
+		t.Errorf("go.sum changed")
+	}
+}
@@ -0,0 +1,188 @@
+package gen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Watcher regenerates the apis of the config file whenever the type file or
+// the config file changes. Only the apis whose annotations or structs changed
+// since the last successful run are regenerated.
+type Watcher struct {
+	configFile string
+	typeFile   string
+	debounce   time.Duration
+	hashes     map[string]string // api path -> hash of its declaration and the config
+}
+
+func NewWatcher(configFile string, debounce time.Duration) *Watcher {
+	return &Watcher{configFile: configFile, debounce: debounce, hashes: map[string]string{}}
+}
+
+// Run generates the changed apis once and then on every change, until the
+// file watcher is closed. Errors of a run are reported and the watcher keeps
+// waiting for the next change.
+func (w *Watcher) Run() error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+
+	w.run()
+	w.watch(fw)
+
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+	for {
+		select {
+		case event, ok := <-fw.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) || !w.isTarget(event.Name) {
+				continue
+			}
+			timer.Reset(w.debounce)
+		case err, ok := <-fw.Errors:
+			if !ok {
+				return nil
+			}
+			logrus.Warningf("Watcher error: %v", err)
+		case <-timer.C:
+			w.run()
+			w.watch(fw)
+		}
+	}
+}
+
+// watch watches the directories of the config file and the type file, so
+// that files replaced by editors on save are still noticed.
+func (w *Watcher) watch(fw *fsnotify.Watcher) {
+	for _, file := range []string{w.configFile, w.typeFile} {
+		if file == "" {
+			continue
+		}
+		if err := fw.Add(filepath.Dir(file)); err != nil {
+			logrus.Warningf("Failed to watch %s: %v", file, err)
+		}
+	}
+}
+
+func (w *Watcher) isTarget(name string) bool {
+	name, _ = filepath.Abs(name)
+	for _, file := range []string{w.configFile, w.typeFile} {
+		if file == "" {
+			continue
+		}
+		if file, _ = filepath.Abs(file); file == name {
+			return true
+		}
+	}
+	return false
+}
+
+// run regenerates the changed apis and prints a summary.
+func (w *Watcher) run() {
+	start := time.Now()
+	// 编辑中途保存的文件不能让监听退出，未生成的 api 保留之前的哈希
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("Failed to regenerate: %v, waiting for changes...", r)
+		}
+	}()
+
+	cfg, err := LoadConfig(w.configFile)
+	if err != nil {
+		logrus.Errorf("%v, waiting for changes...", err)
+		return
+	}
+	w.typeFile = cfg.TypeFile
+
+	hashes, err := apiHashes(cfg)
+	if err != nil {
+		logrus.Errorf("Failed to parse %s: %v, waiting for changes...", cfg.TypeFile, err)
+		return
+	}
+
+	var changed []string
+	for _, api := range cfg.ApiPath {
+		if hash, ok := hashes[api]; !ok || hash != w.hashes[api] {
+			changed = append(changed, api)
+		}
+	}
+	if len(changed) == 0 {
+		fmt.Printf("[%s] no api changed\n", start.Format("15:04:05"))
+		return
+	}
+
+	b := &APIGenBuilder{cfg: cfg}
+	b.WithModule()
+
+	var generated, failed []string
+	for _, api := range changed {
+		if err := b.Generate(api); err != nil {
+			logrus.Errorf("Failed to generate %s: %v", api, err)
+			failed = append(failed, api)
+			continue
+		}
+		w.hashes[api] = hashes[api]
+		generated = append(generated, api)
+	}
+
+	summary := fmt.Sprintf("[%s] regenerated %d api(s)", start.Format("15:04:05"), len(generated))
+	if len(generated) > 0 {
+		summary += " " + strings.Join(generated, ", ")
+	}
+	if len(failed) > 0 {
+		summary += fmt.Sprintf(", %d failed: %s", len(failed), strings.Join(failed, ", "))
+	}
+	fmt.Printf("%s in %s\n", summary, time.Since(start).Round(time.Millisecond))
+}
+
+// apiHashes hashes the declaration of every annotated api in the type file,
+// including its annotations and structs, together with the config except
+// the api paths.
+func apiHashes(cfg Config) (map[string]string, error) {
+	src, err := os.ReadFile(cfg.TypeFile)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, cfg.TypeFile, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.ApiPath = nil
+	cfgData, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := map[string]string{}
+	for _, d := range apiDecls(file) {
+		start := d.decl.Pos()
+		if d.decl.Doc != nil {
+			start = d.decl.Doc.Pos()
+		}
+		// 类型可能声明在其他位置，请求和响应的类型名也计入哈希
+		h := sha256.New()
+		h.Write(cfgData)
+		h.Write(src[fset.Position(start).Offset:fset.Position(d.decl.End()).Offset])
+		fmt.Fprintf(h, "\n%s %s", d.info.Request, d.info.Response)
+		hashes[d.info.Path] = hex.EncodeToString(h.Sum(nil))
+	}
+	return hashes, nil
+}
@@ -1,21 +1,22 @@
 module github.com/ydssx/api-gen
 
-go 1.20
+go 1.25.0
 
 require (
 	github.com/dave/dst v0.27.2
 	github.com/fatih/color v1.15.0
+	github.com/fsnotify/fsnotify v1.10.1
 	github.com/gin-gonic/gin v1.9.0
 	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.9.2
 	github.com/swaggo/gin-swagger v1.6.0
+	golang.org/x/mod v0.35.0
 )
 
 require (
-	github.com/google/go-cmp v0.5.8 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
-	golang.org/x/mod v0.9.0 // indirect
+	golang.org/x/sync v0.20.0 // indirect
 )
 
 require (
@@ -30,7 +31,7 @@ require (
 	github.com/mailru/easyjson v0.7.6 // indirect
 	github.com/swaggo/files v1.0.1
 	github.com/swaggo/swag v1.8.12
-	golang.org/x/tools v0.7.0 // indirect
+	golang.org/x/tools v0.44.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
@@ -52,10 +53,10 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.9 // indirect
 	golang.org/x/arch v0.0.0-20210923205945-b76863e36670 // indirect
-	golang.org/x/crypto v0.5.0 // indirect
-	golang.org/x/net v0.10.0 // indirect
-	golang.org/x/sys v0.8.0 // indirect
-	golang.org/x/text v0.9.0
+	golang.org/x/crypto v0.50.0 // indirect
+	golang.org/x/net v0.53.0 // indirect
+	golang.org/x/sys v0.43.0 // indirect
+	golang.org/x/text v0.36.0
 	google.golang.org/protobuf v1.28.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -2,11 +2,36 @@ package main
 
 import (
 	"flag"
+	"os"
 
+	"github.com/sirupsen/logrus"
 	"github.com/ydssx/api-gen/gen"
 )
 
+// commands are the subcommands of api-gen. Without a subcommand api-gen
+// generates the apis of the config file.
+var commands = map[string]func(args []string) error{
+	"routes":  runRoutes,
+	"check":   runCheck,
+	"watch":   runWatch,
+	"proto":   runProto,
+	"adopt":   runAdopt,
+	"mock":    runMock,
+	"export":  runExport,
+	"docs-md": runDocs,
+	"diff":    runDiff,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				logrus.Fatal(err)
+			}
+			return
+		}
+	}
+
 	var configFile string
 	flag.StringVar(&configFile, "c", "config.yaml", "path to config file")
 	flag.Parse()
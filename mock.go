@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/ydssx/api-gen/gen"
+)
+
+// runMock serves the annotated apis with example responses, or writes the
+// source of the mock server with -o.
+func runMock(args []string) error {
+	fs := flag.NewFlagSet("mock", flag.ExitOnError)
+	configFile := fs.String("c", "config.yaml", "path to config file")
+	addr := fs.String("addr", ":8080", "address to listen on")
+	dir := fs.String("o", "", "write the source of the mock server into the directory instead of serving")
+	seed := fs.Int64("seed", 1, "seed of the faked example values")
+	fs.Parse(args)
+
+	cfg, err := gen.LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	opts := gen.MockOptions{Addr: *addr, Seed: *seed}
+	if *dir != "" {
+		_, err := gen.GenMock(cfg, *dir, opts)
+		return err
+	}
+	return gen.ServeMock(cfg, opts)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/ydssx/api-gen/gen"
+)
+
+// runProto converts the annotated apis into proto services.
+func runProto(args []string) error {
+	fs := flag.NewFlagSet("proto", flag.ExitOnError)
+	configFile := fs.String("c", "config.yaml", "path to config file")
+	dir := fs.String("o", "", "output directory, overrides proto.dir of the config")
+	fs.Parse(args)
+
+	cfg, err := gen.LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	if *dir != "" {
+		cfg.Proto.Dir = *dir
+	}
+	files, err := gen.GenProto(cfg)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		fmt.Println("File", file, "written.")
+	}
+	return nil
+}
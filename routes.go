@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ydssx/api-gen/gen"
+)
+
+// runRoutes prints every route registered by the router func, or by the
+// whole module, without starting the server.
+func runRoutes(args []string) error {
+	fs := flag.NewFlagSet("routes", flag.ExitOnError)
+	configFile := fs.String("c", "config.yaml", "path to config file")
+	all := fs.Bool("all", false, "list the routes of the whole module instead of the router func")
+	asJSON := fs.Bool("json", false, "print the routes as JSON")
+	fs.Parse(args)
+
+	cfg, err := gen.LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	routes, err := gen.ListRoutes(cfg, *all)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(routes)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH\tHANDLER\tMIDDLEWARE")
+	for _, r := range routes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Method, r.Path, r.Handler, strings.Join(r.Middlewares, ", "))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/ydssx/api-gen/gen"
+)
+
+// runWatch regenerates the changed apis whenever the type file or the config
+// file is saved.
+func runWatch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	configFile := fs.String("c", "config.yaml", "path to config file")
+	debounce := fs.Duration("debounce", 300*time.Millisecond, "quiet period after the last change before regenerating")
+	fs.Parse(args)
+
+	return gen.NewWatcher(*configFile, *debounce).Run()
+}